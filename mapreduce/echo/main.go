@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+
+	"mapreduce/internal/logging"
 	n "mapreduce/internal/network"
 )
 
 type handler struct{}
 
-func (s *handler) Process(cmd string) (string, error) {
+func (s *handler) Process(_ context.Context, cmd string) (string, error) {
 	return "ECHO " + cmd, nil
 }
 
 func main() {
+	var logs logging.Config
+	logs.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logs.Setup(); err != nil {
+		log.Fatal(err)
+	}
 	srv := n.NewServer("echo", ":8000", &handler{})
 	srv.Run()
 }
@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+)
+
+// BroadcastJoin make the inner join of the input with a small table in
+// the mappers, run it with mode=map-only broadcast=table. The lines of
+// the input and the table are the key and the value separated by a tab
+const BroadcastJoin = "broadcastjoin"
+
+func init() {
+	mr.Register(mr.NewBroadcastJoinJob[string, string, string, [2]string, [2]string](
+		BroadcastJoin, &broadcastJoinMapImpl{}, nil, nil,
+	))
+}
+
+type broadcastJoinMapImpl struct {
+	keyValueMapImpl
+}
+
+func (m *broadcastJoinMapImpl) JoinKey(line string) string {
+	p, _ := m.keyValueMapImpl.Map(line)
+	return p.Key
+}
+
+// Map a line joined with a row to (key, [value, row])
+func (m *broadcastJoinMapImpl) Map(j mr.Joined[string]) (mr.Pair[string, [2]string], error) {
+	p, err := m.keyValueMapImpl.Map(j.Record)
+	if err != nil {
+		return mr.Pair[string, [2]string]{}, err
+	}
+	return mr.Pair[string, [2]string]{Key: p.Key, Value: [2]string{p.Value, j.Row}}, nil
+}
@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+	"regexp"
+	"sync"
+)
+
+// Grep output the lines matching a pattern with the times they appear,
+// the param.pattern option replaces the pattern of the job
+const Grep = "grep"
+
+// DefaultGrepPattern is the pattern of the registered grep job
+const DefaultGrepPattern = "(?i)error"
+
+func init() {
+	mr.Register(NewGrep(Grep, DefaultGrepPattern))
+}
+
+// NewGrep define a grep job with its own pattern, it must be registered
+// with a name different from Grep
+func NewGrep(name, pattern string) mr.Job {
+	reducer := mr.ContextReducer[string, string, int, int](&wordCountReduceImpl{})
+	return mr.NewContextJob(name, &grepMapImpl{pattern: regexp.MustCompile(pattern)}, reducer, reducer)
+}
+
+type grepMapImpl struct {
+	pattern *regexp.Regexp
+	// patterns compiled from the param.pattern option of the tasks
+	patterns sync.Map
+}
+
+// patternFor the task, the one of the job unless param.pattern is set
+func (m *grepMapImpl) patternFor(ctx *mr.TaskContext) (*regexp.Regexp, error) {
+	expr, ok := ctx.Param("pattern")
+	if !ok {
+		return m.pattern, nil
+	}
+	if p, ok := m.patterns.Load(expr); ok {
+		return p.(*regexp.Regexp), nil
+	}
+	p, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	m.patterns.Store(expr, p)
+	return p, nil
+}
+
+// LineToRecords keep the line only if it matches, counting the lines read and matched
+func (m *grepMapImpl) LineToRecords(ctx *mr.TaskContext, line string) ([]string, error) {
+	pattern, err := m.patternFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.IncrCounter("grep.lines", 1)
+	if !pattern.MatchString(line) {
+		return nil, nil
+	}
+	ctx.IncrCounter("grep.matches", 1)
+	return []string{line}, nil
+}
+
+func (m *grepMapImpl) Map(_ *mr.TaskContext, line string) (mr.Pair[string, int], error) {
+	return mr.Pair[string, int]{Key: line, Value: 1}, nil
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"strings"
+)
+
+// InvertedIndex output for each word the documents where it appears,
+// each input line is a document id and its text separated by a tab
+const InvertedIndex = "invertedindex"
+
+func init() {
+	mr.Register(mr.NewJob(InvertedIndex, &invertedIndexMapImpl{}, nil, &invertedIndexReduceImpl{}))
+}
+
+type wordInDocument struct {
+	word     string
+	document string
+}
+
+type invertedIndexMapImpl struct{}
+
+func (m *invertedIndexMapImpl) LineToRecords(line string) ([]wordInDocument, error) {
+	doc, text, ok := strings.Cut(line, "\t")
+	if !ok {
+		return nil, fmt.Errorf("expected document and text separated by tab: %s", line)
+	}
+	var records []wordInDocument
+	for _, word := range words(text) {
+		records = append(records, wordInDocument{word: word, document: doc})
+	}
+	return records, nil
+}
+
+func (m *invertedIndexMapImpl) Map(r wordInDocument) (mr.Pair[string, string], error) {
+	return mr.Pair[string, string]{Key: r.word, Value: r.document}, nil
+}
+
+type invertedIndexReduceImpl struct{}
+
+// Reduce a word to the sorted list of its documents
+func (r *invertedIndexReduceImpl) Reduce(word string, docs []string) ([]mr.Pair[string, []string], error) {
+	return []mr.Pair[string, []string]{
+		{Key: word, Value: uniqueSorted(docs)},
+	}, nil
+}
@@ -0,0 +1,202 @@
+package jobs_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "mapreduce/internal/jobs"
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/mrtest"
+)
+
+// goldenJob is a built-in job with its input and options in testdata/<job>,
+// its output is testdata/<job>/output.golden
+type goldenJob struct {
+	job string
+	// inputs are files of testdata/<job>, source=file for jobs with several inputs
+	inputs []string
+	// opts are key=value options, values ending in .txt are files of testdata/<job>
+	opts []string
+}
+
+var goldenJobs = []goldenJob{
+	{job: "wordcount", inputs: []string{"input.txt"}},
+	{job: "grep", inputs: []string{"input.txt"}},
+	{job: "invertedindex", inputs: []string{"input.txt"}},
+	{job: "urlfrequency", inputs: []string{"input.txt"}},
+	{job: "reverselinks", inputs: []string{"input.txt"}},
+	{job: "termvector", inputs: []string{"input.txt"}},
+	{job: "sort", inputs: []string{"input.txt"}},
+	{job: "join", inputs: []string{"input.txt"}},
+	{job: "outerjoin", inputs: []string{"left=left.txt", "right=right.txt"}},
+	{job: "broadcastjoin", inputs: []string{"input.txt"}, opts: []string{"mode=map-only", "broadcast=table.txt"}},
+	{job: "stopwordcount", inputs: []string{"input.txt"}, opts: []string{"file.stopwords=stopwords.txt", "param.min=2"}},
+	{job: "timeseries", inputs: []string{"input.txt"}},
+	{job: "pagerank", inputs: []string{"input.txt"}, opts: []string{"iterations=50", "epsilon=0.0001"}},
+	{job: "topk", inputs: []string{"input.txt"}},
+}
+
+// testdata return the absolute path of a file of the job, the workers do not share the directory of the test
+func (g goldenJob) testdata(t *testing.T, fname string) string {
+	t.Helper()
+	abs, err := filepath.Abs(filepath.Join("testdata", g.job, fname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+// resolve the files of a list of key=value or plain names
+func (g goldenJob) resolve(t *testing.T, args []string) []string {
+	resolved := make([]string, len(args))
+	for i, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok:
+			resolved[i] = g.testdata(t, arg)
+		case strings.HasSuffix(value, ".txt"):
+			resolved[i] = key + "=" + g.testdata(t, value)
+		default:
+			resolved[i] = arg
+		}
+	}
+	return resolved
+}
+
+func (g goldenJob) golden(t *testing.T) string {
+	return g.testdata(t, "output.golden")
+}
+
+// localSizes are the mappers and reducers of the local runs
+var localSizes = [][2]int{{1, 1}, {3, 1}}
+
+// runCluster run the job on a cluster with the extra options and return
+// its output as csv lines
+func (g goldenJob) runCluster(t *testing.T, extra ...string) []string {
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	opts := append(append([]string{"job=" + g.job}, g.opts...), extra...)
+	cmd := append([]string{"process", strings.Join(g.resolve(t, g.inputs), ",")}, g.resolve(t, opts)...)
+	lines := c.Run(strings.Join(cmd, " "))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	format := mr.FormatCSV
+	for _, opt := range extra {
+		if name, ok := strings.CutPrefix(opt, "format="); ok {
+			format = name
+		}
+	}
+	return mrtest.ReadOutput(t, lines, format)
+}
+
+// runLocal run the job with a LocalRunner and return its output
+func (g goldenJob) runLocal(t *testing.T, mappers, reducers int) []string {
+	job, err := mr.LookupJob(g.job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err := mr.NewLocalRunner(g.resolve(t, g.opts)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Mappers, runner.Reducers, runner.Dir = mappers, reducers, t.TempDir()
+	result, err := runner.Run(job, g.resolve(t, g.inputs)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, ref := range result.Files {
+		lines = append(lines, mrtest.ReadLines(t, ref.Name)...)
+	}
+	return lines
+}
+
+func TestJobsGolden(t *testing.T) {
+	for _, g := range goldenJobs {
+		t.Run(g.job, func(t *testing.T) {
+			t.Parallel()
+			distributed := g.runCluster(t)
+			mrtest.AssertGolden(t, distributed, g.golden(t))
+			for _, size := range localSizes {
+				t.Run(fmt.Sprintf("local-%dx%d", size[0], size[1]), func(t *testing.T) {
+					lines := g.runLocal(t, size[0], size[1])
+					mrtest.AssertGolden(t, lines, g.golden(t))
+					if !slices.Equal(lines, distributed) {
+						t.Errorf("local output differs from the cluster one")
+					}
+				})
+			}
+		})
+	}
+}
+
+// the outputs of an iteration are read with the format and compression they were written with
+func TestJobsGoldenFormats(t *testing.T) {
+	for _, g := range goldenJobs {
+		if g.job != "pagerank" && g.job != "wordcount" {
+			continue
+		}
+		for _, format := range []string{mr.FormatJSONLines, mr.FormatBinary} {
+			t.Run(g.job+"/"+format, func(t *testing.T) {
+				t.Parallel()
+				lines := g.runCluster(t, "format="+format, "compress-output=gzip")
+				mrtest.AssertGolden(t, lines, g.golden(t))
+			})
+		}
+	}
+}
+
+// topk ranks the counts of the wordcount stage before it
+func TestWordcountTopKPipeline(t *testing.T) {
+	t.Parallel()
+	wordcount := goldenJob{job: "wordcount"}
+	topk := goldenJob{job: "topk"}
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2, Reducers: 2})
+	lines := c.Run("pipeline " + wordcount.testdata(t, "input.txt") + " job=wordcount job=topk")
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("pipeline did not finish: %q", lines)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, mr.FormatCSV), topk.testdata(t, "pipeline.golden"))
+}
+
+// with mode=reduce-only the partitions are sent to the reducers as they are,
+// each one is a reduce task
+func TestReduceOnly(t *testing.T) {
+	t.Parallel()
+	g := goldenJob{job: "wordcount", inputs: []string{"partition-0.txt", "partition-1.txt"}, opts: []string{"mode=reduce-only"}}
+	c := mrtest.Start(t, mrtest.Options{Reducers: 2})
+	lines := c.Run("process " + strings.Join(g.resolve(t, g.inputs), ",") + " job=wordcount mode=reduce-only")
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, mr.FormatCSV), g.golden(t))
+	var reduces []string
+	for _, m := range c.Messages() {
+		if !m.FromMaster {
+			continue
+		}
+		if strings.HasPrefix(m.Line, "map ") {
+			t.Errorf("map task sent: %s", m.Line)
+		}
+		if strings.HasPrefix(m.Line, "reduce ") {
+			reduces = append(reduces, m.Line)
+		}
+	}
+	if len(reduces) != 2 {
+		t.Fatalf("reduce tasks %q, want one for each partition", reduces)
+	}
+	for _, line := range reduces {
+		// reduce r-N <partition-N.txt> <out> <opts>
+		fields := strings.Fields(line)
+		want := g.testdata(t, "partition-"+strings.TrimPrefix(fields[1], "r-")+".txt")
+		if fields[2] != want {
+			t.Errorf("reduce task %q does not read only %s", line, want)
+		}
+	}
+	for _, size := range localSizes {
+		mrtest.AssertGolden(t, g.runLocal(t, size[0], size[1]), g.golden(t))
+	}
+}
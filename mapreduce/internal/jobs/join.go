@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"sort"
+	"strings"
+)
+
+// Join make the inner join of two tables stored in the same input,
+// each line is the table (left or right), the key and the value
+// separated by tabs
+const Join = "join"
+
+func init() {
+	mr.Register(mr.NewJob(Join, &joinMapImpl{}, nil, &joinReduceImpl{}))
+}
+
+// TaggedValue is a value with the table it comes from
+type TaggedValue struct {
+	Table string `json:"table"`
+	Value string `json:"value"`
+}
+
+type tableRow struct {
+	table string
+	key   string
+	value string
+}
+
+type joinMapImpl struct{}
+
+func (m *joinMapImpl) LineToRecords(line string) ([]tableRow, error) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("expected table, key and value separated by tabs: %s", line)
+	}
+	if fields[0] != "left" && fields[0] != "right" {
+		return nil, fmt.Errorf("unknown table %s", fields[0])
+	}
+	return []tableRow{{table: fields[0], key: fields[1], value: fields[2]}}, nil
+}
+
+func (m *joinMapImpl) Map(row tableRow) (mr.Pair[string, TaggedValue], error) {
+	return mr.Pair[string, TaggedValue]{
+		Key:   row.key,
+		Value: TaggedValue{Table: row.table, Value: row.value},
+	}, nil
+}
+
+type joinReduceImpl struct{}
+
+// Reduce emit a pair of values for each combination of the rows of both tables
+func (r *joinReduceImpl) Reduce(key string, values []TaggedValue) ([]mr.Pair[string, [2]string], error) {
+	var left, right []string
+	for _, v := range values {
+		if v.Table == "left" {
+			left = append(left, v.Value)
+		} else {
+			right = append(right, v.Value)
+		}
+	}
+	sort.Strings(left)
+	sort.Strings(right)
+	var rows []mr.Pair[string, [2]string]
+	for _, l := range left {
+		for _, r := range right {
+			rows = append(rows, mr.Pair[string, [2]string]{Key: key, Value: [2]string{l, r}})
+		}
+	}
+	return rows, nil
+}
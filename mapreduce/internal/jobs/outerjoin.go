@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"sort"
+	"strings"
+)
+
+// OuterJoin make the full outer join of two tables, the input names the
+// file of each one as left=file,right=file and each line of the files is
+// the key and the value separated by a tab
+const OuterJoin = "outerjoin"
+
+func init() {
+	mr.Register(mr.NewMultiInputJob(OuterJoin, &outerJoinReduceImpl{},
+		mr.NewInput("left", &keyValueMapImpl{}),
+		mr.NewInput("right", &keyValueMapImpl{}),
+	))
+}
+
+// keyValueMapImpl read lines of key<TAB>value
+type keyValueMapImpl struct{}
+
+func (m *keyValueMapImpl) LineToRecords(line string) ([]string, error) {
+	if line == "" {
+		return nil, nil
+	}
+	return []string{line}, nil
+}
+
+func (m *keyValueMapImpl) Map(line string) (mr.Pair[string, string], error) {
+	key, value, ok := strings.Cut(line, "\t")
+	if !ok {
+		return mr.Pair[string, string]{}, fmt.Errorf("expected key and value separated by tab: %s", line)
+	}
+	return mr.Pair[string, string]{Key: key, Value: value}, nil
+}
+
+type outerJoinReduceImpl struct{}
+
+func (r *outerJoinReduceImpl) Reduce(key string, values []mr.Tagged[string]) ([]mr.Pair[string, mr.JoinRow[string]], error) {
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Value < values[j].Value
+	})
+	var rows []mr.Pair[string, mr.JoinRow[string]]
+	for _, row := range mr.OuterJoin(values, "left", "right") {
+		rows = append(rows, mr.Pair[string, mr.JoinRow[string]]{Key: key, Value: row})
+	}
+	return rows, nil
+}
@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	mr "mapreduce/internal/mapreduce"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PageRank rank the pages of a graph by the pages linking to them, it is an
+// iterative job, run it with iterations=N epsilon=E. The input of the first
+// iteration is a page followed by the pages it links to in each line, the next
+// ones read the csv output of the previous iteration
+const PageRank = "pagerank"
+
+// PageRankDamping is the probability of following a link instead of jumping to a random page
+const PageRankDamping = 0.85
+
+// pageRankPrecision is the number of decimals of the ranks, rounding them makes the
+// output independent of the order in which the contributions are added
+const pageRankPrecision = 1e6
+
+// initialRank of the pages in the first iteration
+const initialRank = 1.0
+
+func init() {
+	mr.Register(mr.NewJob(PageRank, &pageRankMapImpl{}, nil, &pageRankReduceImpl{}))
+}
+
+// Rank of a page and its links, the output of each iteration
+type Rank struct {
+	Rank  float64  `json:"rank"`
+	Links []string `json:"links"`
+}
+
+// rankMessage is the contribution of a page to the rank of a page it links to,
+// or the node with the links of the page and its rank in the previous iteration
+type rankMessage struct {
+	Rank  float64  `json:"rank"`
+	Links []string `json:"links,omitempty"`
+	Node  bool     `json:"node,omitempty"`
+}
+
+// pageRecord is a page with its links or a contribution to a page
+type pageRecord struct {
+	page  string
+	links string
+	rank  float64
+	node  bool
+}
+
+type pageRankMapImpl struct{}
+
+func (m *pageRankMapImpl) LineToRecords(line string) ([]pageRecord, error) {
+	page, rank, err := parseRank(line)
+	if err != nil {
+		return nil, err
+	}
+	if page == "" {
+		return nil, nil
+	}
+	records := []pageRecord{{page: page, links: strings.Join(rank.Links, " "), rank: rank.Rank, node: true}}
+	for _, target := range rank.Links {
+		records = append(records, pageRecord{page: target, rank: rank.Rank / float64(len(rank.Links))})
+	}
+	return records, nil
+}
+
+// parseRank read a line of the output of an iteration or of the graph
+func parseRank(line string) (string, Rank, error) {
+	var rank Rank
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err == nil && len(fields) == 2 && strings.HasPrefix(fields[1], "{") {
+		err = json.Unmarshal([]byte(fields[1]), &rank)
+		return fields[0], rank, err
+	}
+	fields = strings.Fields(line)
+	if len(fields) == 0 {
+		return "", rank, nil
+	}
+	return fields[0], Rank{Rank: initialRank, Links: fields[1:]}, nil
+}
+
+// Map a page to (page, node) and a contribution to (target, contribution)
+func (m *pageRankMapImpl) Map(r pageRecord) (mr.Pair[string, rankMessage], error) {
+	msg := rankMessage{Rank: r.rank, Node: r.node}
+	if r.links != "" {
+		msg.Links = strings.Split(r.links, " ")
+	}
+	return mr.Pair[string, rankMessage]{Key: r.page, Value: msg}, nil
+}
+
+type pageRankReduceImpl struct{}
+
+// Reduce add the contributions to a page, pages without links
+// in the graph are kept with an empty list of links
+func (r *pageRankReduceImpl) Reduce(page string, msgs []rankMessage) ([]mr.Pair[string, Rank], error) {
+	links := []string{}
+	var sum float64
+	for _, msg := range msgs {
+		if msg.Node {
+			links = append(links, msg.Links...)
+			continue
+		}
+		sum += msg.Rank
+	}
+	sort.Strings(links)
+	rank := (1 - PageRankDamping) + PageRankDamping*sum
+	rank = math.Round(rank*pageRankPrecision) / pageRankPrecision
+	return []mr.Pair[string, Rank]{
+		{Key: page, Value: Rank{Rank: rank, Links: links}},
+	}, nil
+}
+
+// Delta is the change of the rank of the page from the previous iteration
+func (r *pageRankReduceImpl) Delta(_ string, msgs []rankMessage, out []mr.Pair[string, Rank]) float64 {
+	previous := initialRank
+	for _, msg := range msgs {
+		if msg.Node {
+			previous = msg.Rank
+		}
+	}
+	return math.Abs(out[0].Value.Rank - previous)
+}
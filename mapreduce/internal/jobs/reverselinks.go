@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+	"strings"
+)
+
+// ReverseLinks output for each page the pages linking to it,
+// each input line is a page followed by the pages it links to
+const ReverseLinks = "reverselinks"
+
+func init() {
+	mr.Register(mr.NewJob(ReverseLinks, &reverseLinksMapImpl{}, nil, &reverseLinksReduceImpl{}))
+}
+
+type link struct {
+	source string
+	target string
+}
+
+type reverseLinksMapImpl struct{}
+
+func (m *reverseLinksMapImpl) LineToRecords(line string) ([]link, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	links := make([]link, 0, len(fields)-1)
+	for _, target := range fields[1:] {
+		links = append(links, link{source: fields[0], target: target})
+	}
+	return links, nil
+}
+
+// Map a link to (target, source)
+func (m *reverseLinksMapImpl) Map(l link) (mr.Pair[string, string], error) {
+	return mr.Pair[string, string]{Key: l.target, Value: l.source}, nil
+}
+
+type reverseLinksReduceImpl struct{}
+
+func (r *reverseLinksReduceImpl) Reduce(target string, sources []string) ([]mr.Pair[string, []string], error) {
+	return []mr.Pair[string, []string]{
+		{Key: target, Value: uniqueSorted(sources)},
+	}, nil
+}
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+	"sort"
+	"strings"
+)
+
+// Sort order records by key, each input line is a key and a value
+// separated by a tab. Run it with partitioner=range so the concatenation
+// of the reducer outputs is sorted
+const Sort = "sort"
+
+func init() {
+	mr.Register(mr.NewJob(Sort, &sortMapImpl{}, nil, &sortReduceImpl{}))
+}
+
+type sortMapImpl struct{}
+
+func (m *sortMapImpl) LineToRecords(line string) ([]mr.Pair[string, string], error) {
+	key, value, _ := strings.Cut(line, "\t")
+	return []mr.Pair[string, string]{{Key: key, Value: value}}, nil
+}
+
+func (m *sortMapImpl) Map(record mr.Pair[string, string]) (mr.Pair[string, string], error) {
+	return record, nil
+}
+
+type sortReduceImpl struct{}
+
+// Reduce emit every record of the key, the framework reduces keys in order
+func (r *sortReduceImpl) Reduce(key string, values []string) ([]mr.Pair[string, string], error) {
+	sort.Strings(values)
+	records := make([]mr.Pair[string, string], len(values))
+	for i, value := range values {
+		records[i] = mr.Pair[string, string]{Key: key, Value: value}
+	}
+	return records, nil
+}
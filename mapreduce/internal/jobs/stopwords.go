@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"bufio"
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"strconv"
+	"strings"
+)
+
+// StopWordCount count the words of the input skipping the words of the
+// side file file.stopwords=path, one per line. The param.min=N option
+// drops the words counted less than N times
+const StopWordCount = "stopwordcount"
+
+func init() {
+	mr.Register(mr.NewTaskJob(StopWordCount, func(task *mr.TaskContext) (mr.Stages[string, string, string, int, int], error) {
+		var stages mr.Stages[string, string, string, int, int]
+		stages.Combiner = &wordCountReduceImpl{}
+		if strings.HasPrefix(task.Task, "m-") {
+			stopWords, err := loadStopWords(task)
+			if err != nil {
+				return stages, err
+			}
+			stages.Mapper = &stopWordCountMapImpl{stopWords: stopWords}
+			return stages, nil
+		}
+		min := 1
+		if v, ok := task.Param("min"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return stages, fmt.Errorf("invalid min %s", v)
+			}
+			min = n
+		}
+		stages.Reducer = &stopWordCountReduceImpl{min: min}
+		return stages, nil
+	}))
+}
+
+func loadStopWords(task *mr.TaskContext) (map[string]bool, error) {
+	file, err := task.OpenSideFile("stopwords")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stopWords := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, word := range words(scanner.Text()) {
+			stopWords[word] = true
+		}
+	}
+	return stopWords, scanner.Err()
+}
+
+type stopWordCountMapImpl struct {
+	wordCountMapImpl
+	stopWords map[string]bool
+}
+
+func (m *stopWordCountMapImpl) LineToRecords(line string) ([]string, error) {
+	var kept []string
+	for _, word := range words(line) {
+		if !m.stopWords[word] {
+			kept = append(kept, word)
+		}
+	}
+	return kept, nil
+}
+
+type stopWordCountReduceImpl struct {
+	wordCountReduceImpl
+	min int
+}
+
+func (r *stopWordCountReduceImpl) Reduce(key string, values []int) ([]mr.Pair[string, int], error) {
+	pairs, err := r.wordCountReduceImpl.Reduce(key, values)
+	if err != nil || pairs[0].Value >= r.min {
+		return pairs, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// TermVector output the most frequent words of the documents of each host,
+// each input line is the url of a document followed by its text
+const TermVector = "termvector"
+
+// MinTermFrequency is the count a word needs to be kept in a term vector
+const MinTermFrequency = 2
+
+func init() {
+	mr.Register(mr.NewJob(TermVector, &termVectorMapImpl{}, &termVectorCombineImpl{}, &termVectorReduceImpl{}))
+}
+
+// TermCount is a word of a term vector with its frequency
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+type document struct {
+	host string
+	text string
+}
+
+type termVectorMapImpl struct{}
+
+func (m *termVectorMapImpl) LineToRecords(line string) ([]document, error) {
+	address, text, _ := strings.Cut(line, " ")
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("expected an url at the start of: %s", line)
+	}
+	return []document{{host: u.Host, text: text}}, nil
+}
+
+// Map a document to (host, term vector of the document)
+func (m *termVectorMapImpl) Map(d document) (mr.Pair[string, map[string]int], error) {
+	terms := map[string]int{}
+	for _, word := range words(d.text) {
+		terms[word]++
+	}
+	return mr.Pair[string, map[string]int]{Key: d.host, Value: terms}, nil
+}
+
+func mergeTermVectors(vectors []map[string]int) map[string]int {
+	merged := map[string]int{}
+	for _, v := range vectors {
+		for term, count := range v {
+			merged[term] += count
+		}
+	}
+	return merged
+}
+
+type termVectorCombineImpl struct{}
+
+// Reduce add the term vectors of a host, infrequent terms are kept
+// because they can be frequent in the output of other mappers
+func (c *termVectorCombineImpl) Reduce(host string, vectors []map[string]int) ([]mr.Pair[string, map[string]int], error) {
+	return []mr.Pair[string, map[string]int]{
+		{Key: host, Value: mergeTermVectors(vectors)},
+	}, nil
+}
+
+type termVectorReduceImpl struct{}
+
+// Reduce add the term vectors of a host dropping the infrequent terms,
+// terms are sorted by frequency
+func (r *termVectorReduceImpl) Reduce(host string, vectors []map[string]int) ([]mr.Pair[string, []TermCount], error) {
+	var terms []TermCount
+	for term, count := range mergeTermVectors(vectors) {
+		if count >= MinTermFrequency {
+			terms = append(terms, TermCount{Term: term, Count: count})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	return []mr.Pair[string, []TermCount]{
+		{Key: host, Value: terms},
+	}, nil
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var wordRegexp = regexp.MustCompile("[a-z]+")
+
+// words split a line in lower case words
+func words(line string) []string {
+	return wordRegexp.FindAllString(strings.ToLower(line), -1)
+}
+
+// uniqueSorted return the distinct values sorted
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,81 @@
+package jobs
+
+import (
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"strconv"
+	"strings"
+)
+
+// TimeSeries output the readings of each sensor sorted by time, each input
+// line is sensor<TAB>time<TAB>value. It sorts the values with a composite
+// key of sensor and time grouped by sensor
+const TimeSeries = "timeseries"
+
+func init() {
+	mr.Register(mr.NewJob(TimeSeries, &timeSeriesMapImpl{}, nil, &timeSeriesReduceImpl{}))
+}
+
+// Reading is the value of a sensor at a time
+type Reading struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// sensorTime is the composite key, sensor is the natural key and time the secondary one
+type sensorTime struct {
+	Sensor string `json:"sensor"`
+	Time   string `json:"time"`
+}
+
+type timeSeriesMapImpl struct{}
+
+func (m *timeSeriesMapImpl) LineToRecords(line string) ([]string, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	return []string{line}, nil
+}
+
+// Map a reading to ((sensor, time), reading)
+func (m *timeSeriesMapImpl) Map(line string) (mr.Pair[sensorTime, Reading], error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 3 {
+		return mr.Pair[sensorTime, Reading]{}, fmt.Errorf("expected sensor, time and value: %s", line)
+	}
+	value, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return mr.Pair[sensorTime, Reading]{}, err
+	}
+	return mr.Pair[sensorTime, Reading]{
+		Key:   sensorTime{Sensor: fields[0], Time: fields[1]},
+		Value: Reading{Time: fields[1], Value: value},
+	}, nil
+}
+
+// PartitionKey send all the readings of a sensor to the same reducer
+func (m *timeSeriesMapImpl) PartitionKey(key sensorTime) string {
+	return key.Sensor
+}
+
+type timeSeriesReduceImpl struct{}
+
+// Reduce receive the readings of a sensor sorted by time
+func (r *timeSeriesReduceImpl) Reduce(key sensorTime, readings []Reading) ([]mr.Pair[string, []Reading], error) {
+	return []mr.Pair[string, []Reading]{
+		{Key: key.Sensor, Value: readings},
+	}, nil
+}
+
+// Compare sort by sensor and time
+func (r *timeSeriesReduceImpl) Compare(a, b sensorTime) int {
+	if c := strings.Compare(a.Sensor, b.Sensor); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Time, b.Time)
+}
+
+// GroupCompare reduce all the times of a sensor together
+func (r *timeSeriesReduceImpl) GroupCompare(a, b sensorTime) int {
+	return strings.Compare(a.Sensor, b.Sensor)
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"encoding/csv"
+	"fmt"
+	mr "mapreduce/internal/mapreduce"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TopK output the TopKSize keys with the highest counts, its input is
+// the csv output of a counting job like wordcount, use it as the next
+// stage of a pipeline
+const TopK = "topk"
+
+// TopKSize is the number of keys kept by TopK
+const TopKSize = 10
+
+// topKKey is the single intermediate key, all the counts go to the same reducer
+const topKKey = "top"
+
+func init() {
+	mr.Register(mr.NewJob(TopK, &topKMapImpl{}, &topKCombineImpl{}, &topKReduceImpl{}))
+}
+
+// KeyCount is a key with its count
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type topKMapImpl struct{}
+
+func (m *topKMapImpl) LineToRecords(line string) ([]KeyCount, error) {
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("expected key and count: %s", line)
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	return []KeyCount{{Key: fields[0], Count: count}}, nil
+}
+
+func (m *topKMapImpl) Map(kc KeyCount) (mr.Pair[string, KeyCount], error) {
+	return mr.Pair[string, KeyCount]{Key: topKKey, Value: kc}, nil
+}
+
+// top sort by count, ties by key, and keep the first TopKSize
+func top(values []KeyCount) []KeyCount {
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Key < values[j].Key
+	})
+	if len(values) > TopKSize {
+		values = values[:TopKSize]
+	}
+	return values
+}
+
+type topKCombineImpl struct{}
+
+// Reduce keep the top of each mapper
+func (c *topKCombineImpl) Reduce(key string, values []KeyCount) ([]mr.Pair[string, KeyCount], error) {
+	var pairs []mr.Pair[string, KeyCount]
+	for _, kc := range top(values) {
+		pairs = append(pairs, mr.Pair[string, KeyCount]{Key: key, Value: kc})
+	}
+	return pairs, nil
+}
+
+type topKReduceImpl struct{}
+
+func (r *topKReduceImpl) Reduce(_ string, values []KeyCount) ([]mr.Pair[string, int], error) {
+	var pairs []mr.Pair[string, int]
+	for _, kc := range top(values) {
+		pairs = append(pairs, mr.Pair[string, int]{Key: kc.Key, Value: kc.Count})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+	"strings"
+)
+
+// URLFrequency count the requests of each url in web server logs
+// using the common log format
+const URLFrequency = "urlfrequency"
+
+func init() {
+	reducer := &wordCountReduceImpl{}
+	mr.Register(mr.NewJob(URLFrequency, &urlFrequencyMapImpl{}, reducer, reducer))
+}
+
+type urlFrequencyMapImpl struct{}
+
+// LineToRecords extract the url from the request, lines without
+// a request are ignored
+func (m *urlFrequencyMapImpl) LineToRecords(line string) ([]string, error) {
+	_, rest, ok := strings.Cut(line, `"`)
+	if !ok {
+		return nil, nil
+	}
+	request, _, ok := strings.Cut(rest, `"`)
+	if !ok {
+		return nil, nil
+	}
+	fields := strings.Fields(request)
+	if len(fields) < 2 {
+		return nil, nil
+	}
+	return []string{fields[1]}, nil
+}
+
+func (m *urlFrequencyMapImpl) Map(url string) (mr.Pair[string, int], error) {
+	return mr.Pair[string, int]{Key: url, Value: 1}, nil
+}
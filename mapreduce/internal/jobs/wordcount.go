@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	mr "mapreduce/internal/mapreduce"
+)
+
+// WordCount count the occurrences of each word of the input
+const WordCount = "wordcount"
+
+func init() {
+	reducer := &wordCountReduceImpl{}
+	mr.Register(mr.NewJob(WordCount, newWordCountMapper(), reducer, reducer))
+}
+
+// Implements Word Count
+type wordCountMapImpl struct{}
+
+func newWordCountMapper() mr.MapImplementation[string, string, int] {
+	return &wordCountMapImpl{}
+}
+
+// LineToRecords in this case split a line in words
+func (m *wordCountMapImpl) LineToRecords(line string) ([]string, error) {
+	return words(line), nil
+}
+
+// Map  a word to (word,1)
+func (m *wordCountMapImpl) Map(word string) (mr.Pair[string, int], error) {
+	return mr.Pair[string, int]{Key: word, Value: 1}, nil
+}
+
+// wordCountReduceImpl sum the counts of each key, it is also the combiner
+// of every job that counts keys
+type wordCountReduceImpl struct{}
+
+// Reduce sum the counts of a word
+func (r *wordCountReduceImpl) Reduce(key string, values []int) ([]mr.Pair[string, int], error) {
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	// we only need to return one value
+	return []mr.Pair[string, int]{
+		{Key: key, Value: sum},
+	}, nil
+}
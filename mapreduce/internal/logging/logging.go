@@ -0,0 +1,56 @@
+// Package logging configure the structured logger of the commands, log/slog,
+// with the level and the format given by their flags. Lines carry the
+// server, worker, job and task they belong to as attributes
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Config of the logger, the flags -log-level and -log-format
+type Config struct {
+	// Level is debug, info, warn or error
+	Level string
+	// Format is text or json
+	Format string
+}
+
+// AddFlags define -log-level and -log-format in fs
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Level, "log-level", "info", "minimum level of the logs: debug, info, warn or error, default: info")
+	fs.StringVar(&c.Format, "log-format", "text", "format of the logs: text or json, default: text")
+}
+
+// NewLogger create a logger writing to w
+func (c Config) NewLogger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if c.Level != "" {
+		err := level.UnmarshalText([]byte(c.Level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %s", c.Level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %s, expected text or json", c.Format)
+}
+
+// Setup make a logger writing to stderr the default one, the
+// messages of the log package are written through it
+func (c Config) Setup() error {
+	logger, err := c.NewLogger(os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
@@ -0,0 +1,101 @@
+package mapreduce
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Counters of the records skipped by the tasks, see JobConfig.MaxBadRecords
+const (
+	CounterMapBadRecords    = "map.bad.records"
+	CounterReduceBadRecords = "reduce.bad.records"
+)
+
+// badRecords skip the records a task can not process when the job allows it,
+// each one is written to the quarantine file of the task as
+// file:line<TAB>error<TAB>record, the error and the record quoted
+type badRecords struct {
+	ctx     *TaskContext
+	fname   string
+	counter string
+	file    *os.File
+	writer  *bufio.Writer
+	skipped int
+}
+
+// newBadRecords for a task writing its quarantine to fname, a file
+// left by a previous attempt is removed
+func newBadRecords(ctx *TaskContext, fname, counter string) *badRecords {
+	if ctx.Config.skipBadRecords() {
+		err := os.Remove(fname)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ctx.Log("can not remove quarantine %s: %s", fname, err)
+		}
+	}
+	return &badRecords{ctx: ctx, fname: fname, counter: counter}
+}
+
+// quarantineFile of a task writing outputs named prefix-N.txt or prefix.txt
+func quarantineFile(prefix string) string {
+	return strings.TrimSuffix(prefix, ".txt") + "-bad.txt"
+}
+
+// skip the record at line of source failed with err, the error is returned
+// when the job does not skip bad records or there are too many of them
+func (b *badRecords) skip(source string, line int, record string, err error) error {
+	if b == nil || !b.ctx.Config.skipBadRecords() {
+		return fmt.Errorf("%s:%d: %w", source, line, err)
+	}
+	if b.file == nil {
+		file, ferr := os.Create(b.fname)
+		if ferr != nil {
+			return fmt.Errorf("creating quarantine %s: %w", b.fname, ferr)
+		}
+		b.file = file
+		b.writer = bufio.NewWriter(file)
+	}
+	_, werr := fmt.Fprintf(b.writer, "%s:%d\t%q\t%q\n", source, line, err.Error(), record)
+	if werr != nil {
+		return fmt.Errorf("writing quarantine %s: %w", b.fname, werr)
+	}
+	b.skipped++
+	b.ctx.IncrCounter(b.counter, 1)
+	limit := b.ctx.Config.MaxBadRecords
+	if limit > 0 && b.skipped > limit {
+		return fmt.Errorf("too many bad records, more than %d, last one %s:%d: %w", limit, source, line, err)
+	}
+	return nil
+}
+
+// check the skipped records do not exceed the percentage of the records read
+func (b *badRecords) check(read int) error {
+	if b == nil {
+		return nil
+	}
+	limit := b.ctx.Config.MaxBadPercent
+	if b.skipped == 0 || limit <= 0 || read == 0 {
+		return nil
+	}
+	percent := float64(b.skipped) * 100 / float64(read)
+	if percent > limit {
+		return fmt.Errorf("too many bad records, %d of %d (%.2f%%) more than %g%%", b.skipped, read, percent, limit)
+	}
+	return nil
+}
+
+// Close the quarantine file, if any record was skipped
+func (b *badRecords) Close() error {
+	if b == nil || b.file == nil {
+		return nil
+	}
+	b.ctx.Log("skipped %d bad records, see %s", b.skipped, b.fname)
+	err := b.writer.Flush()
+	if cerr := b.file.Close(); err == nil {
+		err = cerr
+	}
+	b.file = nil
+	return err
+}
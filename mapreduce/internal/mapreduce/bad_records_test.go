@@ -0,0 +1,123 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// badInput is mapped by failingMapper, lines 2 and 4 have a bad record
+const badInput = "a b\nb fail\nc\nfail\n"
+
+// mapBadInput map badInput with the limits of conf, returns the task context,
+// the path of the input and the prefix of the outputs
+func mapBadInput(t *testing.T, conf JobConfig) (*TaskContext, string, string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	fnIn := filepath.Join(dir, "in.txt")
+	err := os.WriteFile(fnIn, []byte(badInput), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := localTaskContext("m-0", conf)
+	prefix := filepath.Join(dir, "m-out-0")
+	_, err = mapTextFile[string, string, int](ctx, FileRef{Name: fnIn}, prefix, failingMapper{}, nil)
+	return ctx, fnIn, prefix, err
+}
+
+func TestBadRecordFailsTask(t *testing.T) {
+	_, fnIn, prefix, err := mapBadInput(t, DefaultJobConfig())
+	if err == nil || !strings.HasPrefix(err.Error(), fnIn+":2: ") {
+		t.Fatalf("error %v, want the first bad record at %s:2", err, fnIn)
+	}
+	if _, err := os.Stat(quarantineFile(prefix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("quarantine written without skipping: %v", err)
+	}
+}
+
+func TestSkipBadRecords(t *testing.T) {
+	conf := DefaultJobConfig()
+	conf.MaxBadRecords = 2
+	ctx, fnIn, prefix, err := mapBadInput(t, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := ctx.Counters()[CounterMapBadRecords]; n != 2 {
+		t.Errorf("%s = %d, want 2", CounterMapBadRecords, n)
+	}
+	quarantine, err := os.ReadFile(quarantineFile(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%[1]s:2\t\"can not map fail\"\t\"b fail\"\n%[1]s:4\t\"can not map fail\"\t\"fail\"\n", fnIn)
+	if string(quarantine) != want {
+		t.Errorf("quarantine\n%s\nwant\n%s", quarantine, want)
+	}
+	partition, err := os.ReadFile(prefix + "-0.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(partition); got != "a,1\nb,1\nb,1\nc,1\n" {
+		t.Errorf("partition %q, want the records of the good lines", got)
+	}
+}
+
+func TestMaxBadRecords(t *testing.T) {
+	conf := DefaultJobConfig()
+	conf.MaxBadRecords = 1
+	_, fnIn, _, err := mapBadInput(t, conf)
+	if err == nil || !strings.Contains(err.Error(), "too many bad records, more than 1, last one "+fnIn+":4") {
+		t.Fatalf("error %v, want too many bad records at line 4", err)
+	}
+}
+
+func TestMaxBadPercent(t *testing.T) {
+	for _, test := range []struct {
+		percent float64
+		fails   bool
+	}{
+		// 2 of the 4 lines are bad
+		{percent: 40, fails: true},
+		{percent: 50, fails: false},
+	} {
+		t.Run(fmt.Sprint(test.percent), func(t *testing.T) {
+			conf := DefaultJobConfig()
+			conf.MaxBadPercent = test.percent
+			_, _, prefix, err := mapBadInput(t, conf)
+			if test.fails {
+				if err == nil || !strings.Contains(err.Error(), "too many bad records, 2 of 4 (50.00%)") {
+					t.Fatalf("error %v, want too many bad records", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(quarantineFile(prefix)); err != nil {
+				t.Errorf("quarantine not written: %v", err)
+			}
+		})
+	}
+}
+
+// an attempt without bad records does not leave the quarantine of the previous one
+func TestQuarantineOfPreviousAttemptRemoved(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "m-out-0")
+	err := os.WriteFile(quarantineFile(prefix), []byte("old\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := DefaultJobConfig()
+	conf.MaxBadRecords = 1
+	bad := newBadRecords(localTaskContext("m-0", conf), quarantineFile(prefix), CounterMapBadRecords)
+	if err := bad.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(quarantineFile(prefix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("quarantine of the previous attempt left: %v", err)
+	}
+}
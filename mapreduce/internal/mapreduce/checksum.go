@@ -0,0 +1,216 @@
+package mapreduce
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Checksum of a file written by an emitter, the crc is computed
+// over the bytes stored in the file (after compression)
+type Checksum struct {
+	CRC     uint32
+	Records int
+}
+
+func (c Checksum) String() string {
+	return fmt.Sprintf("%08x:%d", c.CRC, c.Records)
+}
+
+// ParseChecksum read a checksum written by Checksum.String
+func ParseChecksum(s string) (c Checksum, err error) {
+	crc, records, ok := strings.Cut(s, ":")
+	if !ok {
+		return c, fmt.Errorf("invalid checksum %s", s)
+	}
+	v, err := strconv.ParseUint(crc, 16, 32)
+	if err != nil {
+		return c, fmt.Errorf("invalid checksum %s: %w", s, err)
+	}
+	c.CRC = uint32(v)
+	c.Records, err = strconv.Atoi(records)
+	if err != nil {
+		return c, fmt.Errorf("invalid checksum %s: %w", s, err)
+	}
+	return c, nil
+}
+
+// ErrChecksumMismatch is returned when a file does not match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// CorruptFileError is returned by a reducer reading a partition that does not
+// match its checksum, the master runs again the map that wrote it
+type CorruptFileError struct {
+	Name string
+	Err  error
+}
+
+func (e *CorruptFileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CorruptFileError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumEmitter is implemented by the emitters that keep the checksum
+// of the file they write, it is complete after Close
+type ChecksumEmitter interface {
+	Checksum() Checksum
+}
+
+// FileRef is a file name with an optional checksum,
+// encoded as name#crc:records in protocol messages
+type FileRef struct {
+	Name     string
+	Checksum *Checksum
+}
+
+func (f FileRef) String() string {
+	if f.Checksum == nil {
+		return f.Name
+	}
+	return f.Name + "#" + f.Checksum.String()
+}
+
+// ParseFileRef read a file reference written by FileRef.String
+func ParseFileRef(s string) (FileRef, error) {
+	name, sum, ok := strings.Cut(s, "#")
+	if !ok {
+		return FileRef{Name: s}, nil
+	}
+	c, err := ParseChecksum(sum)
+	if err != nil {
+		return FileRef{}, err
+	}
+	return FileRef{Name: name, Checksum: &c}, nil
+}
+
+// FormatFileRefs encode a list of file references separated by commas
+func FormatFileRefs(refs []FileRef) string {
+	encoded := make([]string, len(refs))
+	for i, ref := range refs {
+		encoded[i] = ref.String()
+	}
+	return strings.Join(encoded, ",")
+}
+
+// ParseFileRefs read a list written by FormatFileRefs
+func ParseFileRefs(s string) ([]FileRef, error) {
+	var refs []FileRef
+	for _, part := range strings.Split(s, ",") {
+		ref, err := ParseFileRef(part)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// VerifyRecordFile check a file of records encoded with format against its checksum,
+// files without checksum are not verified
+func VerifyRecordFile(ref FileRef, format OutputFormat) error {
+	return verifyFile(ref, func(r io.Reader) (int, error) {
+		reader := format.NewReader(r)
+		records := 0
+		for {
+			_, _, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			if err != nil {
+				return records, err
+			}
+			records++
+		}
+	})
+}
+
+// VerifyTextFile check a text file against its checksum, each line is a record
+func VerifyTextFile(ref FileRef) error {
+	return verifyFile(ref, countLines)
+}
+
+// ChecksumTextFile compute the checksum of a text file, each line is a record
+func ChecksumTextFile(fname string) (Checksum, error) {
+	return fileChecksum(fname, countLines)
+}
+
+func countLines(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	return lines, scanner.Err()
+}
+
+func verifyFile(ref FileRef, count func(io.Reader) (int, error)) error {
+	if ref.Checksum == nil {
+		return nil
+	}
+	got, err := fileChecksum(ref.Name, count)
+	if err != nil {
+		return err
+	}
+	if got != *ref.Checksum {
+		return fmt.Errorf("%w in %s: expected %s, got %s", ErrChecksumMismatch, ref.Name, ref.Checksum, got)
+	}
+	return nil
+}
+
+// fileChecksum compute the crc of the bytes stored in fname
+// and count the records of its decompressed content
+func fileChecksum(fname string, count func(io.Reader) (int, error)) (Checksum, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer file.Close()
+	crc := crc32.NewIEEE()
+	raw := io.TeeReader(file, crc)
+	reader, closer, err := decompress(raw)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("verifying %s: %w", fname, err)
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	records, err := count(reader)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("verifying %s: %w", fname, err)
+	}
+	_, err = io.Copy(io.Discard, raw)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("verifying %s: %w", fname, err)
+	}
+	return Checksum{CRC: crc.Sum32(), Records: records}, nil
+}
+
+// checksumWriter compute the crc of the bytes written through it
+type checksumWriter struct {
+	writer io.Writer
+	crc    hash.Hash32
+	size   int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{
+		writer: w,
+		crc:    crc32.NewIEEE(),
+	}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.crc.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
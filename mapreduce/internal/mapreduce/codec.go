@@ -0,0 +1,190 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Codec converts pairs to and from the key and value fields of a record
+type Codec[K comparable, V any] interface {
+	Encode(Pair[K, V]) (key string, value string, err error)
+	Decode(key, value string) (Pair[K, V], error)
+}
+
+// FieldCodec converts a single key or value to and from a record field
+type FieldCodec[T any] interface {
+	Encode(T) (string, error)
+	Decode(string) (T, error)
+}
+
+// InputCodecProvider can be implemented by a ReduceImplementation
+// to decode the intermediate pairs with its own codec
+type InputCodecProvider[K comparable, V any] interface {
+	InputCodec() Codec[K, V]
+}
+
+// OutputCodecProvider can be implemented by a MapImplementation or a ReduceImplementation
+// to encode the pairs they emit with their own codec
+type OutputCodecProvider[K comparable, V any] interface {
+	OutputCodec() Codec[K, V]
+}
+
+type pairCodec[K comparable, V any] struct {
+	key   FieldCodec[K]
+	value FieldCodec[V]
+}
+
+// NewCodec build a codec from a codec for keys and a codec for values
+func NewCodec[K comparable, V any](key FieldCodec[K], value FieldCodec[V]) Codec[K, V] {
+	return &pairCodec[K, V]{
+		key:   key,
+		value: value,
+	}
+}
+
+// DefaultCodec encode scalar types as text and any other type as json
+func DefaultCodec[K comparable, V any]() Codec[K, V] {
+	return NewCodec(DefaultFieldCodec[K](), DefaultFieldCodec[V]())
+}
+
+func (c *pairCodec[K, V]) Encode(p Pair[K, V]) (string, string, error) {
+	key, err := c.key.Encode(p.Key)
+	if err != nil {
+		return "", "", fmt.Errorf("encoding key %v: %w", p.Key, err)
+	}
+	value, err := c.value.Encode(p.Value)
+	if err != nil {
+		return "", "", fmt.Errorf("encoding value %v: %w", p.Value, err)
+	}
+	return key, value, nil
+}
+
+func (c *pairCodec[K, V]) Decode(key, value string) (p Pair[K, V], err error) {
+	p.Key, err = c.key.Decode(key)
+	if err != nil {
+		return p, fmt.Errorf("decoding key %q: %w", key, err)
+	}
+	p.Value, err = c.value.Decode(value)
+	if err != nil {
+		return p, fmt.Errorf("decoding value %q: %w", value, err)
+	}
+	return p, nil
+}
+
+func inputCodecFor[K comparable, V any](impl any) Codec[K, V] {
+	if p, ok := impl.(InputCodecProvider[K, V]); ok {
+		return p.InputCodec()
+	}
+	return DefaultCodec[K, V]()
+}
+
+func outputCodecFor[K comparable, V any](impl any) Codec[K, V] {
+	if p, ok := impl.(OutputCodecProvider[K, V]); ok {
+		return p.OutputCodec()
+	}
+	return DefaultCodec[K, V]()
+}
+
+// DefaultFieldCodec return a text codec for strings, booleans, integers and floats
+// (including named types based on them) and a json codec for anything else
+func DefaultFieldCodec[T any]() FieldCodec[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	switch t.Kind() {
+	case reflect.String, reflect.Bool:
+		return scalarCodec[T]{kind: t.Kind()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return scalarCodec[T]{kind: t.Kind(), bits: t.Bits()}
+	}
+	return JSONCodec[T]{}
+}
+
+type scalarCodec[T any] struct {
+	kind reflect.Kind
+	bits int
+}
+
+func (c scalarCodec[T]) Encode(v T) (string, error) {
+	rv := reflect.ValueOf(&v).Elem()
+	switch c.kind {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	default:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, c.bits), nil
+	}
+}
+
+func (c scalarCodec[T]) Decode(s string) (v T, err error) {
+	rv := reflect.ValueOf(&v).Elem()
+	switch c.kind {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, c.bits)
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, c.bits)
+		rv.SetUint(u)
+	default:
+		var f float64
+		f, err = strconv.ParseFloat(s, c.bits)
+		rv.SetFloat(f)
+	}
+	return
+}
+
+// JSONCodec encode a field with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (JSONCodec[T]) Decode(s string) (v T, err error) {
+	err = json.Unmarshal([]byte(s), &v)
+	return
+}
+
+// GobCodec encode a field with encoding/gob, the result is base64 encoded
+// so it can be stored by any output format
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) (string, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func (GobCodec[T]) Decode(s string) (v T, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return v, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return
+}
@@ -0,0 +1,104 @@
+package mapreduce
+
+import (
+	"reflect"
+	"testing"
+)
+
+type level int
+
+// coord is a comparable key encoded as json
+type coord struct {
+	Name string
+	X, Y int
+}
+
+type point struct {
+	X, Y float64
+	Tags []string
+}
+
+// roundTrip encode and decode p with codec, the fields must be the expected ones
+func roundTrip[K comparable, V any](t *testing.T, codec Codec[K, V], p Pair[K, V], key, value string) {
+	t.Helper()
+	k, v, err := codec.Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" && k != key || value != "" && v != value {
+		t.Errorf("%v encoded as %q %q, want %q %q", p, k, v, key, value)
+	}
+	got, err := codec.Decode(k, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("%v decoded as %v", p, got)
+	}
+}
+
+func TestDefaultCodec(t *testing.T) {
+	roundTrip(t, DefaultCodec[string, string](), Pair[string, string]{Key: "a,b", Value: "c\nd"}, "a,b", "c\nd")
+	roundTrip(t, DefaultCodec[int, float64](), Pair[int, float64]{Key: -12, Value: 0.1}, "-12", "0.1")
+	roundTrip(t, DefaultCodec[uint8, bool](), Pair[uint8, bool]{Key: 255, Value: true}, "255", "true")
+	roundTrip(t, DefaultCodec[level, float32](), Pair[level, float32]{Key: 3, Value: 1.5}, "3", "1.5")
+	roundTrip(t, DefaultCodec[int64, point](), Pair[int64, point]{Key: 1 << 40, Value: point{X: 1, Y: -2, Tags: []string{"a"}}}, "1099511627776", `{"X":1,"Y":-2,"Tags":["a"]}`)
+	roundTrip(t, DefaultCodec[[2]int, []string](), Pair[[2]int, []string]{Key: [2]int{1, 2}, Value: []string{"x", "y"}}, "[1,2]", `["x","y"]`)
+}
+
+func TestDefaultCodecErrors(t *testing.T) {
+	for _, test := range []struct {
+		key, value string
+		decode     func(key, value string) error
+	}{
+		{"x", "1", func(k, v string) error { _, err := DefaultCodec[int, int]().Decode(k, v); return err }},
+		{"1", "1.5", func(k, v string) error { _, err := DefaultCodec[int, int]().Decode(k, v); return err }},
+		{"256", "true", func(k, v string) error { _, err := DefaultCodec[uint8, bool]().Decode(k, v); return err }},
+		{"1", "maybe", func(k, v string) error { _, err := DefaultCodec[uint8, bool]().Decode(k, v); return err }},
+		{"1", "{", func(k, v string) error { _, err := DefaultCodec[int, point]().Decode(k, v); return err }},
+	} {
+		if err := test.decode(test.key, test.value); err == nil {
+			t.Errorf("%q %q decoded without error", test.key, test.value)
+		}
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	codec := NewCodec[string, point](DefaultFieldCodec[string](), GobCodec[point]{})
+	roundTrip(t, codec, Pair[string, point]{Key: "p", Value: point{X: 0.5, Y: 2, Tags: []string{"a", "b,c"}}}, "p", "")
+	_, v, err := codec.Encode(Pair[string, point]{Key: "p", Value: point{X: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// base64 has no separator of the formats
+	for _, c := range v {
+		if c == ',' || c == '\n' || c == '"' {
+			t.Fatalf("gob value %q has %q", v, c)
+		}
+	}
+	if _, err := codec.Decode("p", "not base64!"); err == nil {
+		t.Error("invalid base64 decoded")
+	}
+}
+
+func TestCodecsWithFormats(t *testing.T) {
+	codec := NewCodec[coord, point](DefaultFieldCodec[coord](), GobCodec[point]{})
+	p := Pair[coord, point]{Key: coord{Name: "k,\"ey\"\n", X: 1}, Value: point{Y: 2, Tags: []string{"v\nalue"}}}
+	for _, format := range []OutputFormat{csvFormat{}, jsonLinesFormat{}, binaryFormat{}} {
+		key, value, err := codec.Encode(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := decodeRecords(format, encodeRecords(t, format, [][2]string{{key, value}}))
+		if err != nil || len(records) != 1 {
+			t.Fatalf("%s: %q %v", format.Name(), records, err)
+		}
+		got, err := codec.Decode(records[0][0], records[0][1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("%s: %v decoded as %v", format.Name(), p, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package mapreduce
+
+// combiningEmitter group the pairs emitted by a mapper by key and
+// reduce them with the combiner when closed, before writing them to out
+type combiningEmitter[K comparable, V any] struct {
+	ctx      *TaskContext
+	combiner ReduceContextImplementation[K, K, V, V]
+	out      Emitter[Pair[K, V]]
+	keys     []K
+	values   map[K][]V
+}
+
+func newCombiningEmitter[K comparable, V any](
+	ctx *TaskContext,
+	combiner ReduceContextImplementation[K, K, V, V],
+	out Emitter[Pair[K, V]],
+) *combiningEmitter[K, V] {
+	return &combiningEmitter[K, V]{
+		ctx:      ctx,
+		combiner: combiner,
+		out:      out,
+		values:   map[K][]V{},
+	}
+}
+
+func (c *combiningEmitter[K, V]) Emit(p Pair[K, V]) error {
+	values, ok := c.values[p.Key]
+	if !ok {
+		c.keys = append(c.keys, p.Key)
+	}
+	c.values[p.Key] = append(values, p.Value)
+	return nil
+}
+
+// Close combine the values of each key, in the order the keys were first emitted,
+// on error out is left open for the caller to abort its partial partitions
+func (c *combiningEmitter[K, V]) Close() error {
+	for _, key := range c.keys {
+		pairs, err := c.combiner.Reduce(c.ctx, key, c.values[key])
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			err = c.out.Emit(p)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return c.out.Close()
+}
@@ -0,0 +1,239 @@
+package mapreduce
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Compression of intermediate and output files
+type Compression interface {
+	Name() string
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZlib = "zlib"
+)
+
+var compressions = map[string]Compression{
+	CompressionNone: noCompression{},
+	CompressionGzip: gzipCompression{},
+	CompressionZlib: zlibCompression{},
+}
+
+// CompressionByName return the compression registered with name
+func CompressionByName(name string) (Compression, error) {
+	c, ok := compressions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %s", name)
+	}
+	return c, nil
+}
+
+type noCompression struct{}
+
+func (noCompression) Name() string {
+	return CompressionNone
+}
+
+func (noCompression) NewWriter(w io.Writer) io.WriteCloser {
+	return nopWriteCloser{w}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type gzipCompression struct{}
+
+func (gzipCompression) Name() string {
+	return CompressionGzip
+}
+
+func (gzipCompression) NewWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+type zlibCompression struct{}
+
+func (zlibCompression) Name() string {
+	return CompressionZlib
+}
+
+func (zlibCompression) NewWriter(w io.Writer) io.WriteCloser {
+	return zlib.NewWriter(w)
+}
+
+// outputFile write through the compressor and keep a checksum
+// of the bytes stored in the file
+type outputFile struct {
+	io.WriteCloser
+	file *os.File
+	sum  *checksumWriter
+	// rename is the final name of a file created by createFileAtomic
+	rename string
+}
+
+func (c *outputFile) Close() error {
+	err := c.WriteCloser.Close()
+	if err != nil {
+		c.abort()
+		return err
+	}
+	err = c.file.Close()
+	if err == nil {
+		bytesEmitted.Add(float64(c.sum.size))
+	}
+	if c.rename == "" {
+		return err
+	}
+	if err == nil {
+		err = os.Rename(c.file.Name(), c.rename)
+	}
+	if err != nil {
+		os.Remove(c.file.Name())
+	}
+	return err
+}
+
+// abort close and remove the file, a file created by createFileAtomic keeps
+// the previous content of its final name
+func (c *outputFile) abort() {
+	c.file.Close()
+	os.Remove(c.file.Name())
+}
+
+// crc of the bytes written to the file, only complete after Close
+func (c *outputFile) crc() uint32 {
+	return c.sum.crc.Sum32()
+}
+
+// createFile create fname writing through compression
+func createFile(fname string, compression Compression) (*outputFile, error) {
+	file, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	return newOutputFile(file, compression), nil
+}
+
+func newOutputFile(file *os.File, compression Compression) *outputFile {
+	sum := newChecksumWriter(file)
+	return &outputFile{
+		WriteCloser: compression.NewWriter(sum),
+		file:        file,
+		sum:         sum,
+	}
+}
+
+// createFileAtomic write to a temporary file renamed to fname when closed,
+// so a failed task never replaces the previous content of fname, the temporary
+// name is unique so attempts writing the same fname do not share it
+func createFileAtomic(fname string, compression Compression) (*outputFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp is private to the user, outputs are readable as with os.Create
+	err = file.Chmod(0644)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	out := newOutputFile(file, compression)
+	out.rename = fname
+	return out, nil
+}
+
+// decompressedFile close the decompressor before closing the file
+type decompressedFile struct {
+	io.Reader
+	closer  io.Closer
+	file    *os.File
+	counter *countingReader
+}
+
+func (d *decompressedFile) Close() error {
+	if d.closer != nil {
+		d.closer.Close()
+	}
+	bytesRead.Add(float64(d.counter.n))
+	return d.file.Close()
+}
+
+// openFile open fname for reading, if the file starts with a gzip
+// or zlib header the content is decompressed
+func openFile(fname string) (io.ReadCloser, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{reader: file}
+	reader, closer, err := decompress(counter)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("opening %s: %w", fname, err)
+	}
+	return &decompressedFile{Reader: reader, closer: closer, file: file, counter: counter}, nil
+}
+
+// sniffSize is the prefix of a file decoded to confirm its compression
+const sniffSize = 4096
+
+// decompress detect the compression of r by its header, confirmed by decoding
+// the start of the content, the closer is nil when r is not compressed
+func decompress(r io.Reader) (io.Reader, io.Closer, error) {
+	buffered := bufio.NewReaderSize(r, sniffSize)
+	prefix, _ := buffered.Peek(sniffSize)
+	switch {
+	case isGzipHeader(prefix) && decodes(prefix, newGzipReader):
+		reader, err := gzip.NewReader(buffered)
+		return reader, reader, err
+	case isZlibHeader(prefix) && decodes(prefix, zlib.NewReader):
+		reader, err := zlib.NewReader(buffered)
+		return reader, reader, err
+	}
+	return buffered, nil, nil
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// decodes tell if the prefix of a file is valid for the decompressor, a prefix
+// shorter than sniffSize is the whole file and must decode up to its checksum
+func decodes(prefix []byte, newReader func(io.Reader) (io.ReadCloser, error)) bool {
+	reader, err := newReader(bytes.NewReader(prefix))
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	_, err = io.Copy(io.Discard, reader)
+	return err == nil || (len(prefix) == sniffSize && err == io.ErrUnexpectedEOF)
+}
+
+func isGzipHeader(header []byte) bool {
+	return len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b
+}
+
+// isZlibHeader check for deflate with a 32K window and a valid header checksum,
+// text starting with x^ matches too, decompress decodes the content to tell them apart
+func isZlibHeader(header []byte) bool {
+	if len(header) < 2 || header[0] != 0x78 {
+		return false
+	}
+	return (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[1]&0x20 == 0
+}
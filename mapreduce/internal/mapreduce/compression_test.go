@@ -0,0 +1,82 @@
+package mapreduce
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, fname string) string {
+	t.Helper()
+	in, err := openFile(fname)
+	if err != nil {
+		t.Fatalf("open %s: %s", fname, err)
+	}
+	defer in.Close()
+	data, err := io.ReadAll(in)
+	if err != nil {
+		t.Fatalf("read %s: %s", fname, err)
+	}
+	return string(data)
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	content := strings.Repeat("the quick brown fox,1\n", 1000)
+	for _, name := range []string{CompressionNone, CompressionGzip, CompressionZlib} {
+		t.Run(name, func(t *testing.T) {
+			comp, err := CompressionByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fname := filepath.Join(t.TempDir(), "out.txt")
+			out, err := createFileAtomic(fname, comp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(out, content)
+			if err := out.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readAll(t, fname); got != content {
+				t.Errorf("read %d bytes, want %d", len(got), len(content))
+			}
+		})
+	}
+}
+
+// text starting with x^ has a valid zlib header, it must be read as text
+func TestTextLikeZlibHeader(t *testing.T) {
+	for _, content := range []string{"x^2 + y^2,1\n", "x^" + strings.Repeat("abcdefgh,1\n", 1000)} {
+		fname := filepath.Join(t.TempDir(), "in.txt")
+		err := os.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, fname); got != content {
+			t.Errorf("read %q..., want %q...", got[:min(len(got), 20)], content[:20])
+		}
+	}
+}
+
+func TestCloseRemovesTemporaryFile(t *testing.T) {
+	dir := t.TempDir()
+	// the rename fails, the final name is a directory
+	fname := filepath.Join(dir, "out.txt")
+	err := os.Mkdir(fname, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := createFileAtomic(fname, gzipCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(out, "a,1\n")
+	if err := out.Close(); err == nil {
+		t.Fatal("expected an error renaming over a directory")
+	}
+	if tmp, _ := filepath.Glob(fname + ".*.tmp"); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
@@ -0,0 +1,246 @@
+package mapreduce
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OutputFormat encodes and decodes the key/value records written by mappers,
+// the shuffle and reducers
+type OutputFormat interface {
+	Name() string
+	NewWriter(w io.Writer) RecordWriter
+	NewReader(r io.Reader) RecordReader
+}
+
+// RecordWriter writes key/value records into a stream
+type RecordWriter interface {
+	Write(key, value string) error
+	Flush() error
+}
+
+// RecordReader reads key/value records from a stream,
+// returns io.EOF when there are no more records
+type RecordReader interface {
+	Read() (key, value string, err error)
+}
+
+// BadRecordError is returned by a RecordReader for a malformed record
+// it can skip, the next Read returns the following record
+type BadRecordError struct {
+	Line   int
+	Record string
+	Err    error
+}
+
+func (e *BadRecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *BadRecordError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	FormatCSV       = "csv"
+	FormatJSONLines = "jsonl"
+	FormatBinary    = "binary"
+)
+
+var formats = map[string]OutputFormat{
+	FormatCSV:       csvFormat{},
+	FormatJSONLines: jsonLinesFormat{},
+	FormatBinary:    binaryFormat{},
+}
+
+// DefaultFormat is used when a job does not specify one
+var DefaultFormat OutputFormat = csvFormat{}
+
+// FormatByName return the output format registered with name
+func FormatByName(name string) (OutputFormat, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %s", name)
+	}
+	return f, nil
+}
+
+// csvFormat writes one record per line, fields are quoted when needed
+type csvFormat struct{}
+
+func (csvFormat) Name() string {
+	return FormatCSV
+}
+
+func (csvFormat) NewWriter(w io.Writer) RecordWriter {
+	return &csvRecordWriter{writer: csv.NewWriter(w)}
+}
+
+func (csvFormat) NewReader(r io.Reader) RecordReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.ReuseRecord = true
+	return &csvRecordReader{reader: reader}
+}
+
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvRecordWriter) Write(key, value string) error {
+	return c.writer.Write([]string{key, value})
+}
+
+func (c *csvRecordWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+}
+
+// Read a record, lines with a wrong number of fields are bad records
+func (c *csvRecordReader) Read() (string, string, error) {
+	record, err := c.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount) {
+		return "", "", &BadRecordError{Line: parseErr.Line, Record: strings.Join(record, ","), Err: parseErr.Err}
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return record[0], record[1], nil
+}
+
+// jsonLinesFormat writes one json object per line
+type jsonLinesFormat struct{}
+
+type jsonRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (jsonLinesFormat) Name() string {
+	return FormatJSONLines
+}
+
+func (jsonLinesFormat) NewWriter(w io.Writer) RecordWriter {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return &jsonRecordWriter{writer: writer, encoder: encoder}
+}
+
+func (jsonLinesFormat) NewReader(r io.Reader) RecordReader {
+	return &jsonRecordReader{decoder: json.NewDecoder(r)}
+}
+
+type jsonRecordWriter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (j *jsonRecordWriter) Write(key, value string) error {
+	return j.encoder.Encode(jsonRecord{Key: key, Value: value})
+}
+
+func (j *jsonRecordWriter) Flush() error {
+	return j.writer.Flush()
+}
+
+type jsonRecordReader struct {
+	decoder *json.Decoder
+}
+
+func (j *jsonRecordReader) Read() (string, string, error) {
+	var record jsonRecord
+	err := j.decoder.Decode(&record)
+	if err != nil {
+		return "", "", err
+	}
+	return record.Key, record.Value, nil
+}
+
+// binaryFormat writes each field prefixed by its length as an uvarint
+type binaryFormat struct{}
+
+func (binaryFormat) Name() string {
+	return FormatBinary
+}
+
+func (binaryFormat) NewWriter(w io.Writer) RecordWriter {
+	return &binaryRecordWriter{writer: bufio.NewWriter(w)}
+}
+
+func (binaryFormat) NewReader(r io.Reader) RecordReader {
+	return &binaryRecordReader{reader: bufio.NewReader(r)}
+}
+
+type binaryRecordWriter struct {
+	writer *bufio.Writer
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func (b *binaryRecordWriter) Write(key, value string) error {
+	err := b.writeField(key)
+	if err != nil {
+		return err
+	}
+	return b.writeField(value)
+}
+
+func (b *binaryRecordWriter) writeField(field string) error {
+	n := binary.PutUvarint(b.buf[:], uint64(len(field)))
+	_, err := b.writer.Write(b.buf[:n])
+	if err != nil {
+		return err
+	}
+	_, err = b.writer.WriteString(field)
+	return err
+}
+
+func (b *binaryRecordWriter) Flush() error {
+	return b.writer.Flush()
+}
+
+type binaryRecordReader struct {
+	reader *bufio.Reader
+}
+
+func (b *binaryRecordReader) Read() (string, string, error) {
+	key, err := b.readField()
+	if err != nil {
+		return "", "", err
+	}
+	value, err := b.readField()
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+func (b *binaryRecordReader) readField() (string, error) {
+	size, err := binary.ReadUvarint(b.reader)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(b.reader, buf)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
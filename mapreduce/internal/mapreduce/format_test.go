@@ -0,0 +1,122 @@
+package mapreduce
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// records with the characters the formats must escape
+var formatRecords = [][2]string{
+	{"plain", "1"},
+	{"comma,key", "value,with,commas"},
+	{`"quoted"`, `say "hi"`},
+	{"multi\nline", "a\r\nb\n"},
+	{"", ""},
+	{" spaces ", "\ttab"},
+	{"unicode é", `{"json":"value"}`},
+}
+
+func encodeRecords(t *testing.T, format OutputFormat, records [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := format.NewWriter(&buf)
+	for _, r := range records {
+		if err := w.Write(r[0], r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeRecords(format OutputFormat, data []byte) ([][2]string, error) {
+	r := format.NewReader(bytes.NewReader(data))
+	var records [][2]string
+	for {
+		key, value, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, [2]string{key, value})
+	}
+}
+
+func TestFormatsRoundTrip(t *testing.T) {
+	for _, name := range []string{FormatCSV, FormatJSONLines, FormatBinary} {
+		t.Run(name, func(t *testing.T) {
+			format, err := FormatByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format.Name() != name {
+				t.Errorf("name %q", format.Name())
+			}
+			records := formatRecords
+			if name == FormatCSV {
+				// encoding/csv reads \r\n inside quotes as \n
+				records = append([][2]string{}, formatRecords...)
+				records[3][1] = "a\nb\n"
+			}
+			got, err := decodeRecords(format, encodeRecords(t, format, formatRecords))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(records) {
+				t.Fatalf("read %q, want %q", got, records)
+			}
+			for i := range records {
+				if got[i] != records[i] {
+					t.Errorf("record %d is %q, want %q", i, got[i], records[i])
+				}
+			}
+		})
+	}
+	if _, err := FormatByName("xml"); err == nil {
+		t.Error("unknown format found")
+	}
+}
+
+func TestCSVQuoting(t *testing.T) {
+	data := encodeRecords(t, csvFormat{}, [][2]string{{"a,b", `c"d`}, {"e\nf", "g"}})
+	want := "\"a,b\",\"c\"\"d\"\n\"e\nf\",g\n"
+	if string(data) != want {
+		t.Errorf("csv %q, want %q", data, want)
+	}
+}
+
+func TestCSVBadRecord(t *testing.T) {
+	r := csvFormat{}.NewReader(bytes.NewReader([]byte("a,1\nb,2,3\nc,3\n")))
+	if key, _, err := r.Read(); err != nil || key != "a" {
+		t.Fatalf("first record %q %v", key, err)
+	}
+	_, _, err := r.Read()
+	var bad *BadRecordError
+	if !errors.As(err, &bad) || bad.Line != 2 || bad.Record != "b,2,3" {
+		t.Fatalf("error %v, want the bad record at line 2", err)
+	}
+	if key, _, err := r.Read(); err != nil || key != "c" {
+		t.Errorf("record after the bad one %q %v", key, err)
+	}
+}
+
+func TestBinaryTruncated(t *testing.T) {
+	data := encodeRecords(t, binaryFormat{}, [][2]string{{"key", "value"}, {"other", "record"}})
+	first := len(encodeRecords(t, binaryFormat{}, [][2]string{{"key", "value"}}))
+	// every cut inside the second record is an unexpected end, not the end of the records
+	for cut := first + 1; cut < len(data); cut++ {
+		got, err := decodeRecords(binaryFormat{}, data[:cut])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("cut at %d of %d: %v, want unexpected EOF", cut, len(data), err)
+		}
+		if len(got) != 1 {
+			t.Errorf("cut at %d: read %q, want the first record", cut, got)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Job is a named map reduce algorithm, built with NewJob and
+// registered with Register so workers can run it by name
+type Job interface {
+	Name() string
+	runMap(task *TaskContext, in FileRef, outPrefix string) (TaskResult, error)
+	runReduce(task *TaskContext, in []FileRef, out string) (TaskResult, error)
+}
+
+// job hold the implementations of every stage, K1 are the records of the input,
+// K2 and V2 the intermediate pairs and K3 and V3 the output pairs
+type job[K1, K2, K3 comparable, V2, V3 any] struct {
+	name     string
+	mapper   MapContextImplementation[K1, K2, V2]
+	combiner ReduceContextImplementation[K2, K2, V2, V2]
+	reducer  ReduceContextImplementation[K2, K3, V2, V3]
+}
+
+// NewJob define a job from its map, combine and reduce implementations,
+// combiner is optional, when present it reduces the output of each mapper
+// before it is written
+func NewJob[K1, K2, K3 comparable, V2, V3 any](
+	name string,
+	mapper MapImplementation[K1, K2, V2],
+	combiner ReduceImplementation[K2, K2, V2, V2],
+	reducer ReduceImplementation[K2, K3, V2, V3],
+) Job {
+	return NewContextJob(name, ContextMapper(mapper), ContextReducer(combiner), ContextReducer(reducer))
+}
+
+// NewContextJob define a job from implementations that receive the context
+// of the task in every call
+func NewContextJob[K1, K2, K3 comparable, V2, V3 any](
+	name string,
+	mapper MapContextImplementation[K1, K2, V2],
+	combiner ReduceContextImplementation[K2, K2, V2, V2],
+	reducer ReduceContextImplementation[K2, K3, V2, V3],
+) Job {
+	return &job[K1, K2, K3, V2, V3]{
+		name:     name,
+		mapper:   mapper,
+		combiner: combiner,
+		reducer:  reducer,
+	}
+}
+
+// wrapper is implemented by the adapters of implementations,
+// optional interfaces are looked up in the implementation they wrap
+type wrapper interface {
+	unwrap() any
+}
+
+func implementationOf(impl any) any {
+	for {
+		w, ok := impl.(wrapper)
+		if !ok {
+			return impl
+		}
+		impl = w.unwrap()
+	}
+}
+
+func (j *job[K1, K2, K3, V2, V3]) Name() string {
+	return j.name
+}
+
+func (j *job[K1, K2, K3, V2, V3]) runMap(task *TaskContext, in FileRef, outPrefix string) (TaskResult, error) {
+	parts, err := mapTextFile(task, in, outPrefix, j.mapper, j.combiner)
+	return TaskResult{Files: parts}, err
+}
+
+func (j *job[K1, K2, K3, V2, V3]) runReduce(task *TaskContext, in []FileRef, out string) (TaskResult, error) {
+	if j.reducer == nil {
+		return TaskResult{}, fmt.Errorf("job %s has no reducer, run it with mode=%s", j.name, ModeMapOnly)
+	}
+	return reduceTextFile(task, in, out, j.reducer)
+}
+
+// Stages are the implementations of a job created for a task, see NewTaskJob
+type Stages[K1, K2, K3 comparable, V2, V3 any] struct {
+	Mapper   MapImplementation[K1, K2, V2]
+	Combiner ReduceImplementation[K2, K2, V2, V2]
+	Reducer  ReduceImplementation[K2, K3, V2, V3]
+}
+
+// taskJob create the implementations of the job for each task
+type taskJob[K1, K2, K3 comparable, V2, V3 any] struct {
+	name  string
+	setup func(task *TaskContext) (Stages[K1, K2, K3, V2, V3], error)
+}
+
+// NewTaskJob define a job whose implementations are created by setup for each
+// task, with the task context to load the side files and parameters of the job
+// before mapping or reducing. Setup only needs to create the implementations
+// of the stage of the task
+func NewTaskJob[K1, K2, K3 comparable, V2, V3 any](
+	name string,
+	setup func(task *TaskContext) (Stages[K1, K2, K3, V2, V3], error),
+) Job {
+	return &taskJob[K1, K2, K3, V2, V3]{name: name, setup: setup}
+}
+
+func (j *taskJob[K1, K2, K3, V2, V3]) Name() string {
+	return j.name
+}
+
+func (j *taskJob[K1, K2, K3, V2, V3]) runMap(task *TaskContext, in FileRef, outPrefix string) (TaskResult, error) {
+	stages, err := j.setup(task)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	if stages.Mapper == nil {
+		return TaskResult{}, fmt.Errorf("job %s has no mapper", j.name)
+	}
+	return NewJob(j.name, stages.Mapper, stages.Combiner, stages.Reducer).runMap(task, in, outPrefix)
+}
+
+func (j *taskJob[K1, K2, K3, V2, V3]) runReduce(task *TaskContext, in []FileRef, out string) (TaskResult, error) {
+	stages, err := j.setup(task)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	return NewJob(j.name, stages.Mapper, stages.Combiner, stages.Reducer).runReduce(task, in, out)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Job{}
+)
+
+// Register make a job available to the workers of this process,
+// it panics if a job with the same name is already registered
+func Register(job Job) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[job.Name()]; dup {
+		panic(fmt.Sprintf("mapreduce: job %s registered twice", job.Name()))
+	}
+	registry[job.Name()] = job
+}
+
+// LookupJob return the registered job with name
+func LookupJob(name string) (Job, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	job, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown job %s", name)
+	}
+	return job, nil
+}
+
+// RegisteredJobs return the names of the registered jobs sorted
+func RegisteredJobs() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupJobs find the registered jobs with the given names
+func lookupJobs(names []string) (map[string]Job, error) {
+	jobs := make(map[string]Job, len(names))
+	for _, name := range names {
+		job, err := LookupJob(name)
+		if err != nil {
+			return nil, err
+		}
+		jobs[name] = job
+	}
+	return jobs, nil
+}
@@ -0,0 +1,278 @@
+package mapreduce
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mapreduce/internal/tracing"
+)
+
+// Modes of a job, the stages it runs
+const (
+	ModeMapReduce = "mapreduce"
+	// ModeMapOnly skip the reduce stage, the mapper outputs are the output of the job
+	ModeMapOnly = "map-only"
+	// ModeReduceOnly skip the map stage, each input file is a partition
+	// of pairs already keyed, encoded with the format of the job
+	ModeReduceOnly = "reduce-only"
+)
+
+// JobConfig holds the options of a job, the master sends them
+// to the workers as key=value arguments of the map and reduce commands
+type JobConfig struct {
+	// Job is the name of the registered job to run
+	Job string
+	// Mode is ModeMapReduce, ModeMapOnly or ModeReduceOnly
+	Mode   string
+	Format OutputFormat
+	// Compression of mapper outputs and shuffle partitions
+	Compression Compression
+	// OutputCompression of the reducer outputs
+	OutputCompression Compression
+	// Partitions is the number of files written by each mapper, one per reducer
+	Partitions  int
+	Partitioner Partitioner
+	// Iterations is the maximum number of times the job is run feeding
+	// its output back as input, see Convergence
+	Iterations int
+	// Epsilon stops the iterations when the delta reported by the reducers is not greater
+	Epsilon float64
+	// Source is the tag of the input of a map task of a job with several inputs,
+	// the master sets it for each task
+	Source string
+	// Trace is the span of the master for the attempt of a task, the parent of
+	// the span of the worker running it. The master sets it for each attempt
+	Trace tracing.SpanContext
+	// Broadcast is the table read by every mapper of a map side join,
+	// the master adds its checksum as for side files
+	Broadcast FileRef
+	// Params are the param.<name>=value options, the values are escaped in the arguments
+	Params map[string]string
+	// Files are the side files attached with file.<name>=path, see TaskContext
+	Files map[string]FileRef
+	// MaxBadRecords is the number of records a task can skip, the lines the
+	// mapper fails to map and the records the reducer fails to decode. A task
+	// fails on the first bad record unless it or MaxBadPercent is set
+	MaxBadRecords int
+	// MaxBadPercent is the percentage of the records read a task can skip
+	MaxBadPercent float64
+	// MapWorkers is the number of goroutines mapping the lines of a map task,
+	// the mapper must be safe for concurrent use when it is greater than 1
+	MapWorkers int
+	// BackupAfter is the time after which the master sends a backup attempt of a
+	// running task to another worker, the first attempt done wins. 0 disables them
+	BackupAfter time.Duration
+}
+
+func DefaultJobConfig() JobConfig {
+	return JobConfig{
+		Mode:              ModeMapReduce,
+		Format:            DefaultFormat,
+		Compression:       noCompression{},
+		OutputCompression: noCompression{},
+		Partitions:        1,
+		Partitioner:       DefaultPartitioner,
+		Iterations:        1,
+		MapWorkers:        1,
+	}
+}
+
+// ParseJobConfig read key=value options over the default config
+func ParseJobConfig(args []string) (JobConfig, error) {
+	return DefaultJobConfig().With(args)
+}
+
+// With return a copy of the config with the key=value options applied
+func (c JobConfig) With(args []string) (JobConfig, error) {
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return c, fmt.Errorf("invalid job option %s, expected key=value", arg)
+		}
+		if name, ok := strings.CutPrefix(key, "param."); ok {
+			v, err := url.QueryUnescape(value)
+			if err != nil {
+				return c, fmt.Errorf("invalid param %s: %w", name, err)
+			}
+			c.Params = with(c.Params, name, v)
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "file."); ok {
+			ref, err := ParseFileRef(value)
+			if err != nil || ref.Name == "" {
+				return c, fmt.Errorf("invalid side file %s", name)
+			}
+			c.Files = with(c.Files, name, ref)
+			continue
+		}
+		switch key {
+		case "job":
+			c.Job = value
+		case "mode":
+			switch value {
+			case ModeMapReduce, ModeMapOnly, ModeReduceOnly:
+				c.Mode = value
+			default:
+				return c, fmt.Errorf("unknown mode %s", value)
+			}
+		case "format":
+			f, err := FormatByName(value)
+			if err != nil {
+				return c, err
+			}
+			c.Format = f
+		case "compress":
+			comp, err := CompressionByName(value)
+			if err != nil {
+				return c, err
+			}
+			c.Compression = comp
+		case "compress-output":
+			comp, err := CompressionByName(value)
+			if err != nil {
+				return c, err
+			}
+			c.OutputCompression = comp
+		case "partitions":
+			parts, err := strconv.Atoi(value)
+			if err != nil || parts < 1 {
+				return c, fmt.Errorf("invalid partitions %s", value)
+			}
+			c.Partitions = parts
+		case "partitioner":
+			p, err := PartitionerByName(value)
+			if err != nil {
+				return c, err
+			}
+			c.Partitioner = p
+		case "iterations":
+			iterations, err := strconv.Atoi(value)
+			if err != nil || iterations < 1 {
+				return c, fmt.Errorf("invalid iterations %s", value)
+			}
+			c.Iterations = iterations
+		case "epsilon":
+			epsilon, err := strconv.ParseFloat(value, 64)
+			if err != nil || epsilon < 0 {
+				return c, fmt.Errorf("invalid epsilon %s", value)
+			}
+			c.Epsilon = epsilon
+		case "source":
+			c.Source = value
+		case "traceparent":
+			trace, err := tracing.ParseSpanContext(value)
+			if err != nil {
+				return c, err
+			}
+			c.Trace = trace
+		case "broadcast":
+			ref, err := ParseFileRef(value)
+			if err != nil || ref.Name == "" {
+				return c, fmt.Errorf("invalid broadcast table %s", value)
+			}
+			c.Broadcast = ref
+		case "max-bad-records":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return c, fmt.Errorf("invalid max-bad-records %s", value)
+			}
+			c.MaxBadRecords = n
+		case "max-bad-percent":
+			percent, err := strconv.ParseFloat(value, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return c, fmt.Errorf("invalid max-bad-percent %s", value)
+			}
+			c.MaxBadPercent = percent
+		case "map-workers":
+			workers, err := strconv.Atoi(value)
+			if err != nil || workers < 1 {
+				return c, fmt.Errorf("invalid map-workers %s", value)
+			}
+			c.MapWorkers = workers
+		case "backup-after":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return c, fmt.Errorf("invalid backup-after %s", value)
+			}
+			c.BackupAfter = d
+		default:
+			return c, fmt.Errorf("unknown job option %s", key)
+		}
+	}
+	return c, nil
+}
+
+// Args encode the config as key=value arguments
+func (c JobConfig) Args() []string {
+	args := []string{
+		"job=" + c.Job,
+		"mode=" + c.Mode,
+		"format=" + c.Format.Name(),
+		"compress=" + c.Compression.Name(),
+		"compress-output=" + c.OutputCompression.Name(),
+		"partitions=" + strconv.Itoa(c.Partitions),
+		"partitioner=" + c.Partitioner.Name(),
+		"iterations=" + strconv.Itoa(c.Iterations),
+		"epsilon=" + strconv.FormatFloat(c.Epsilon, 'g', -1, 64),
+	}
+	if c.Source != "" {
+		args = append(args, "source="+c.Source)
+	}
+	if c.Trace.IsValid() {
+		args = append(args, "traceparent="+c.Trace.String())
+	}
+	if c.Broadcast.Name != "" {
+		args = append(args, "broadcast="+c.Broadcast.String())
+	}
+	if c.MaxBadRecords > 0 {
+		args = append(args, "max-bad-records="+strconv.Itoa(c.MaxBadRecords))
+	}
+	if c.MaxBadPercent > 0 {
+		args = append(args, "max-bad-percent="+strconv.FormatFloat(c.MaxBadPercent, 'g', -1, 64))
+	}
+	if c.MapWorkers > 1 {
+		args = append(args, "map-workers="+strconv.Itoa(c.MapWorkers))
+	}
+	if c.BackupAfter > 0 {
+		args = append(args, "backup-after="+c.BackupAfter.String())
+	}
+	for _, name := range sortedKeys(c.Params) {
+		args = append(args, "param."+name+"="+url.QueryEscape(c.Params[name]))
+	}
+	for _, name := range sortedKeys(c.Files) {
+		args = append(args, "file."+name+"="+c.Files[name].String())
+	}
+	return args
+}
+
+// skipBadRecords tell if the tasks skip the records they fail to process
+func (c JobConfig) skipBadRecords() bool {
+	return c.MaxBadRecords > 0 || c.MaxBadPercent > 0
+}
+
+// with return a copy of m with the key set, configs share their maps
+func with[V any](m map[string]V, key string, value V) map[string]V {
+	c := make(map[string]V, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	c[key] = value
+	return c
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c JobConfig) String() string {
+	return strings.Join(c.Args(), " ")
+}
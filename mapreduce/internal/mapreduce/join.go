@@ -0,0 +1,285 @@
+package mapreduce
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tagged is an intermediate value with the source of the input it comes from
+type Tagged[V any] struct {
+	Source string `json:"source"`
+	Value  V      `json:"value"`
+}
+
+// Input is a tagged input of a job with several sources, see NewMultiInputJob
+type Input[K2 comparable, V any] interface {
+	Source() string
+	runMap(task *TaskContext, in FileRef, outPrefix string) ([]FileRef, error)
+}
+
+type input[K1, K2 comparable, V any] struct {
+	source string
+	mapper MapContextImplementation[K1, K2, V]
+}
+
+// NewInput define the mapper of the files of a source
+func NewInput[K1, K2 comparable, V any](source string, mapper MapImplementation[K1, K2, V]) Input[K2, V] {
+	return NewContextInput(source, ContextMapper(mapper))
+}
+
+// NewContextInput is NewInput for a mapper that receives the context of the task
+func NewContextInput[K1, K2 comparable, V any](source string, mapper MapContextImplementation[K1, K2, V]) Input[K2, V] {
+	return &input[K1, K2, V]{source: source, mapper: mapper}
+}
+
+func (i *input[K1, K2, V]) Source() string {
+	return i.source
+}
+
+func (i *input[K1, K2, V]) runMap(task *TaskContext, in FileRef, outPrefix string) ([]FileRef, error) {
+	return mapTextFile[K1, K2, Tagged[V]](task, in, outPrefix, &taggingMapper[K1, K2, V]{source: i.source, mapper: i.mapper}, nil)
+}
+
+// taggingMapper tag the values of a mapper with its source
+type taggingMapper[K1, K2 comparable, V any] struct {
+	source string
+	mapper MapContextImplementation[K1, K2, V]
+}
+
+func (t *taggingMapper[K1, K2, V]) LineToRecords(ctx *TaskContext, line string) ([]K1, error) {
+	return t.mapper.LineToRecords(ctx, line)
+}
+
+func (t *taggingMapper[K1, K2, V]) Map(ctx *TaskContext, key K1) (Pair[K2, Tagged[V]], error) {
+	p, err := t.mapper.Map(ctx, key)
+	if err != nil {
+		return Pair[K2, Tagged[V]]{}, err
+	}
+	return Pair[K2, Tagged[V]]{Key: p.Key, Value: Tagged[V]{Source: t.source, Value: p.Value}}, nil
+}
+
+// unwrap let the mapper partition the keys, the values are tagged so its codec is not used
+func (t *taggingMapper[K1, K2, V]) unwrap() any {
+	return t.mapper
+}
+
+// multiInputJob map each source with its own mapper, the reducer gets the tagged values
+type multiInputJob[K2, K3 comparable, V2, V3 any] struct {
+	name    string
+	inputs  map[string]Input[K2, V2]
+	reducer ReduceContextImplementation[K2, K3, Tagged[V2], V3]
+}
+
+// NewMultiInputJob define a job reading several tagged inputs, each one with its
+// own mapper. The input of the process command names the file of each source,
+// source=file separated by commas, and the reducer gets the values tagged with
+// their source, see GroupBySource and the join helpers
+func NewMultiInputJob[K2, K3 comparable, V2, V3 any](
+	name string,
+	reducer ReduceImplementation[K2, K3, Tagged[V2], V3],
+	inputs ...Input[K2, V2],
+) Job {
+	j := &multiInputJob[K2, K3, V2, V3]{
+		name:    name,
+		inputs:  make(map[string]Input[K2, V2], len(inputs)),
+		reducer: ContextReducer(reducer),
+	}
+	for _, in := range inputs {
+		j.inputs[in.Source()] = in
+	}
+	return j
+}
+
+func (j *multiInputJob[K2, K3, V2, V3]) Name() string {
+	return j.name
+}
+
+func (j *multiInputJob[K2, K3, V2, V3]) runMap(task *TaskContext, in FileRef, outPrefix string) (TaskResult, error) {
+	conf := task.Config
+	if conf.Source == "" {
+		return TaskResult{}, fmt.Errorf("job %s expects tagged inputs, source=file", j.name)
+	}
+	source, ok := j.inputs[conf.Source]
+	if !ok {
+		return TaskResult{}, fmt.Errorf("job %s has no input %q", j.name, conf.Source)
+	}
+	parts, err := source.runMap(task, in, outPrefix)
+	return TaskResult{Files: parts}, err
+}
+
+func (j *multiInputJob[K2, K3, V2, V3]) runReduce(task *TaskContext, in []FileRef, out string) (TaskResult, error) {
+	return reduceTextFile(task, in, out, j.reducer)
+}
+
+// GroupBySource return the values of each source
+func GroupBySource[V any](values []Tagged[V]) map[string][]V {
+	groups := map[string][]V{}
+	for _, v := range values {
+		groups[v.Source] = append(groups[v.Source], v.Value)
+	}
+	return groups
+}
+
+// JoinRow is a row of a join, a side is nil when it has no value for the key
+type JoinRow[V any] struct {
+	Left  *V `json:"left"`
+	Right *V `json:"right"`
+}
+
+// InnerJoin combine every value of the left source with every value of the right one
+func InnerJoin[V any](values []Tagged[V], left, right string) []JoinRow[V] {
+	return join(values, left, right, false, false)
+}
+
+// LeftJoin is InnerJoin keeping the left values without right ones
+func LeftJoin[V any](values []Tagged[V], left, right string) []JoinRow[V] {
+	return join(values, left, right, true, false)
+}
+
+// OuterJoin is InnerJoin keeping the values of both sides without matches
+func OuterJoin[V any](values []Tagged[V], left, right string) []JoinRow[V] {
+	return join(values, left, right, true, true)
+}
+
+func join[V any](values []Tagged[V], left, right string, keepLeft, keepRight bool) []JoinRow[V] {
+	groups := GroupBySource(values)
+	lefts, rights := pointers(groups[left]), pointers(groups[right])
+	if len(lefts) == 0 && keepRight {
+		lefts = []*V{nil}
+	}
+	if len(rights) == 0 && keepLeft {
+		rights = []*V{nil}
+	}
+	var rows []JoinRow[V]
+	for _, l := range lefts {
+		for _, r := range rights {
+			if l == nil && r == nil {
+				continue
+			}
+			rows = append(rows, JoinRow[V]{Left: l, Right: r})
+		}
+	}
+	return rows
+}
+
+func pointers[V any](values []V) []*V {
+	ptrs := make([]*V, len(values))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	return ptrs
+}
+
+// Joined is a record of a map side join with a row of the broadcast table
+type Joined[K comparable] struct {
+	Record K
+	Row    string
+}
+
+// BroadcastMapImplementation is the mapper of a map side join, each record is
+// joined with the rows of the broadcast table with its JoinKey before Map,
+// records without rows are dropped
+type BroadcastMapImplementation[K1, K2 comparable, V any] interface {
+	LineToRecords(line string) ([]K1, error)
+	JoinKey(record K1) string
+	Map(record Joined[K1]) (Pair[K2, V], error)
+}
+
+// broadcastJoinJob load the broadcast table of the job in every map task
+type broadcastJoinJob[K1, K2, K3 comparable, V2, V3 any] struct {
+	job[Joined[K1], K2, K3, V2, V3]
+	broadcast BroadcastMapImplementation[K1, K2, V2]
+}
+
+// NewBroadcastJoinJob define a map side join of the input with a small table,
+// the job option broadcast=file names the table, read by every mapper. Each line
+// of the table is a key and a row separated by a tab. The reducer is optional
+// for jobs run with mode=map-only
+func NewBroadcastJoinJob[K1, K2, K3 comparable, V2, V3 any](
+	name string,
+	mapper BroadcastMapImplementation[K1, K2, V2],
+	combiner ReduceImplementation[K2, K2, V2, V2],
+	reducer ReduceImplementation[K2, K3, V2, V3],
+) Job {
+	return &broadcastJoinJob[K1, K2, K3, V2, V3]{
+		job: job[Joined[K1], K2, K3, V2, V3]{
+			name:     name,
+			combiner: ContextReducer(combiner),
+			reducer:  ContextReducer(reducer),
+		},
+		broadcast: mapper,
+	}
+}
+
+func (j *broadcastJoinJob[K1, K2, K3, V2, V3]) runMap(task *TaskContext, in FileRef, outPrefix string) (TaskResult, error) {
+	conf := task.Config
+	if conf.Broadcast.Name == "" {
+		return TaskResult{}, fmt.Errorf("job %s needs a broadcast table", j.name)
+	}
+	// the table is verified and cached as the side files
+	ref, err := localizeSideFile(conf.Broadcast)
+	if err != nil {
+		return TaskResult{}, fmt.Errorf("broadcast table: %w", err)
+	}
+	table, err := LoadBroadcastTable(ref.Name)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	mapper := &broadcastJoinMapper[K1, K2, V2]{mapper: j.broadcast, table: table}
+	parts, err := mapTextFile[Joined[K1], K2, V2](task, in, outPrefix, mapper, j.combiner)
+	return TaskResult{Files: parts}, err
+}
+
+type broadcastJoinMapper[K1, K2 comparable, V any] struct {
+	mapper BroadcastMapImplementation[K1, K2, V]
+	table  map[string][]string
+}
+
+func (b *broadcastJoinMapper[K1, K2, V]) LineToRecords(_ *TaskContext, line string) ([]Joined[K1], error) {
+	records, err := b.mapper.LineToRecords(line)
+	if err != nil {
+		return nil, err
+	}
+	var joined []Joined[K1]
+	for _, r := range records {
+		for _, row := range b.table[b.mapper.JoinKey(r)] {
+			joined = append(joined, Joined[K1]{Record: r, Row: row})
+		}
+	}
+	return joined, nil
+}
+
+func (b *broadcastJoinMapper[K1, K2, V]) Map(_ *TaskContext, record Joined[K1]) (Pair[K2, V], error) {
+	return b.mapper.Map(record)
+}
+
+func (b *broadcastJoinMapper[K1, K2, V]) unwrap() any {
+	return b.mapper
+}
+
+// LoadBroadcastTable read a table of key<TAB>row lines, the rows of each key are sorted
+func LoadBroadcastTable(fname string) (map[string][]string, error) {
+	file, err := openFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	table := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, row, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		table[key] = append(table[key], row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading broadcast table %s: %w", fname, err)
+	}
+	for _, rows := range table {
+		sort.Strings(rows)
+	}
+	return table, nil
+}
@@ -0,0 +1,53 @@
+package mapreduce
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tableJoin join each word of a line with the rows of the table
+type tableJoin struct{}
+
+func (tableJoin) LineToRecords(line string) ([]string, error) {
+	return strings.Fields(line), nil
+}
+
+func (tableJoin) JoinKey(word string) string {
+	return word
+}
+
+func (tableJoin) Map(record Joined[string]) (Pair[string, string], error) {
+	return Pair[string, string]{Key: record.Record, Value: record.Row}, nil
+}
+
+func TestBroadcastTableVerified(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := SideFileCacheDir
+	SideFileCacheDir = filepath.Join(dir, "cache")
+	t.Cleanup(func() { SideFileCacheDir = cacheDir })
+	fnIn := filepath.Join(dir, "in.txt")
+	fnTable := filepath.Join(dir, "table.txt")
+	for fname, content := range map[string]string{fnIn: "a b\n", fnTable: "a\tone\nb\ttwo\n"} {
+		if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := ChecksumTextFile(fnTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the table changed after the master computed its checksum
+	if err := os.WriteFile(fnTable, []byte("a\tother\nb\ttwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := NewBroadcastJoinJob[string, string, string, string, string]("tablejoin", tableJoin{}, nil, nil)
+	conf := DefaultJobConfig()
+	conf.Partitions = 1
+	conf.Broadcast = FileRef{Name: fnTable, Checksum: &sum}
+	_, err = job.runMap(localTaskContext("m-0", conf), FileRef{Name: fnIn}, filepath.Join(dir, "m-out-0"))
+	if err == nil || !strings.Contains(err.Error(), "broadcast table") {
+		t.Fatalf("expected a checksum error of the broadcast table, got %v", err)
+	}
+}
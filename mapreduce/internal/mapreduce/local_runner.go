@@ -0,0 +1,297 @@
+package mapreduce
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LocalRunner run a job in a single process, every task on its own goroutine,
+// without master nor workers. It splits the input, maps, combines and partitions
+// the pairs and reduces them as the workers do, so jobs can be tested with the
+// same implementations they are registered with
+type LocalRunner struct {
+	// Config are the options of the job, the runner sets Job and Partitions
+	Config JobConfig
+	// Mappers is the number of map tasks, the input is split in as many parts
+	Mappers int
+	// Reducers is the number of reduce tasks and partitions
+	Reducers int
+	// Dir is the work directory, a temporary one removed by Close when empty
+	Dir string
+
+	tempDir string
+}
+
+// LocalResult is the output of a job run by a LocalRunner
+type LocalResult struct {
+	// Files written by the reducers, or by the mappers of map only jobs
+	Files []FileRef
+	// Counters of every task of every iteration
+	Counters map[string]int64
+	// Delta of the last iteration, nil when the reducer does not implement Convergence
+	Delta *float64
+	// Iterations run until the job converged
+	Iterations int
+}
+
+// NewLocalRunner create a runner with a mapper and a reducer,
+// opts are key=value job options as in the process command
+func NewLocalRunner(opts ...string) (*LocalRunner, error) {
+	conf, err := ParseJobConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalRunner{Config: conf, Mappers: 1, Reducers: 1}, nil
+}
+
+// Run the job over the inputs, files or source=file for jobs with several inputs
+func (r *LocalRunner) Run(job Job, inputs ...string) (LocalResult, error) {
+	var result LocalResult
+	if len(inputs) == 0 {
+		return result, fmt.Errorf("job %s has no input", job.Name())
+	}
+	dir, err := r.workDir()
+	if err != nil {
+		return result, err
+	}
+	conf := r.Config
+	conf.Job = job.Name()
+	conf.Partitions = max(r.Reducers, 1)
+	if conf.Mode != ModeMapReduce {
+		conf.Partitions = 1
+	}
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	sources := localSources(inputs)
+	// the inputs are text files, the next iterations read the records of the outputs
+	var format OutputFormat
+	for {
+		outputs, err := r.runIteration(job, conf, prefix, sources, format, &result)
+		if err != nil {
+			return result, err
+		}
+		result.Files = outputs
+		result.Iterations++
+		if result.Iterations >= conf.Iterations || (result.Delta != nil && *result.Delta <= conf.Epsilon) {
+			return result, nil
+		}
+		sources = map[string][]FileRef{"": outputs}
+		format = conf.Format
+	}
+}
+
+// RunLocal run the job and read its output, the pairs are decoded with DefaultCodec
+func RunLocal[K comparable, V any](r *LocalRunner, job Job, inputs ...string) ([]Pair[K, V], error) {
+	result, err := r.Run(job, inputs...)
+	if err != nil {
+		return nil, err
+	}
+	return ReadOutput[K, V](result.Files, r.Config.Format, nil)
+}
+
+// ReadOutput read the pairs of the output files of a job in order,
+// codec is the one of the reducer, DefaultCodec when nil
+func ReadOutput[K comparable, V any](files []FileRef, format OutputFormat, codec Codec[K, V]) ([]Pair[K, V], error) {
+	if codec == nil {
+		codec = DefaultCodec[K, V]()
+	}
+	var pairs []Pair[K, V]
+	for _, ref := range files {
+		err := VerifyRecordFile(ref, format)
+		if err != nil {
+			return nil, err
+		}
+		in, err := NewRecordFileIterator(ref.Name, format)
+		if err != nil {
+			return nil, err
+		}
+		for in.Next() {
+			record := in.Value()
+			p, err := codec.Decode(record.Key, record.Value)
+			if err != nil {
+				in.Close()
+				return nil, fmt.Errorf("%s: %w", ref.Name, err)
+			}
+			pairs = append(pairs, p)
+		}
+		err = in.Error()
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// Close remove the temporary work directory
+func (r *LocalRunner) Close() error {
+	if r.tempDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(r.tempDir)
+	r.tempDir = ""
+	return err
+}
+
+func (r *LocalRunner) workDir() (string, error) {
+	if r.Dir != "" {
+		return r.Dir, nil
+	}
+	if r.tempDir == "" {
+		dir, err := os.MkdirTemp("", "mapreduce-local-")
+		if err != nil {
+			return "", err
+		}
+		r.tempDir = dir
+	}
+	return r.tempDir, nil
+}
+
+// runIteration run the stages of the mode of the job, returns the final outputs.
+// The sources are text files when format is nil, otherwise records written with it
+func (r *LocalRunner) runIteration(job Job, conf JobConfig, prefix string, sources map[string][]FileRef, format OutputFormat, result *LocalResult) ([]FileRef, error) {
+	if conf.Mode == ModeReduceOnly {
+		var inputs [][]FileRef
+		for _, source := range sortedKeys(sources) {
+			for _, ref := range sources[source] {
+				inputs = append(inputs, []FileRef{ref})
+			}
+		}
+		return r.reduce(job, conf, prefix, inputs, result)
+	}
+	var tasks []localTask
+	for _, source := range sortedKeys(sources) {
+		splitPrefix := prefix + "m"
+		if source != "" {
+			splitPrefix += "-" + source
+		}
+		var parts []FileRef
+		var err error
+		if format != nil {
+			parts, err = SplitRecordFiles(sources[source], format, splitPrefix, max(r.Mappers, 1))
+		} else {
+			parts, err = SplitTextFiles(fileNames(sources[source]), splitPrefix, max(r.Mappers, 1))
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range parts {
+			i := len(tasks)
+			tasks = append(tasks, localTask{
+				id:     fmt.Sprintf("m-%d", i),
+				source: source,
+				in:     []FileRef{part},
+				out:    fmt.Sprintf("%sm-out-%d", prefix, i),
+			})
+		}
+	}
+	results, err := runLocalTasks(tasks, conf, result, func(task *TaskContext, t localTask) (TaskResult, error) {
+		return job.runMap(task, t.in[0], t.out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if conf.Mode == ModeMapOnly {
+		var outputs []FileRef
+		for _, res := range results {
+			outputs = append(outputs, res.Files...)
+		}
+		return outputs, nil
+	}
+	partitions := make([][]FileRef, conf.Partitions)
+	for _, res := range results {
+		for i, part := range res.Files {
+			partitions[i] = append(partitions[i], part)
+		}
+	}
+	return r.reduce(job, conf, prefix, partitions, result)
+}
+
+// reduce run a reduce task for each list of inputs
+func (r *LocalRunner) reduce(job Job, conf JobConfig, prefix string, inputs [][]FileRef, result *LocalResult) ([]FileRef, error) {
+	tasks := make([]localTask, len(inputs))
+	for i, in := range inputs {
+		tasks[i] = localTask{
+			id:  fmt.Sprintf("r-%d", i),
+			in:  in,
+			out: fmt.Sprintf("%sr-out-%d.txt", prefix, i),
+		}
+	}
+	results, err := runLocalTasks(tasks, conf, result, func(task *TaskContext, t localTask) (TaskResult, error) {
+		return job.runReduce(task, t.in, t.out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var outputs []FileRef
+	result.Delta = nil
+	for _, res := range results {
+		outputs = append(outputs, res.Files...)
+		if res.Delta != nil {
+			if result.Delta == nil {
+				result.Delta = new(float64)
+			}
+			*result.Delta += *res.Delta
+		}
+	}
+	return outputs, nil
+}
+
+type localTask struct {
+	id     string
+	source string
+	in     []FileRef
+	out    string
+}
+
+// runLocalTasks run every task on its own goroutine and add their counters
+// to the result, returns the error of the first task that failed
+func runLocalTasks(tasks []localTask, conf JobConfig, result *LocalResult,
+	run func(task *TaskContext, t localTask) (TaskResult, error),
+) ([]TaskResult, error) {
+	results := make([]TaskResult, len(tasks))
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Add(1)
+		go func(i int, t localTask) {
+			defer wg.Done()
+			taskConf := conf
+			taskConf.Source = t.source
+			task := localTaskContext(t.id, taskConf)
+			results[i], errs[i] = run(task, t)
+			results[i].Counters = task.Counters()
+		}(i, t)
+	}
+	wg.Wait()
+	for i, res := range results {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("task %s: %w", tasks[i].id, errs[i])
+		}
+		result.Counters = AddCounters(result.Counters, res.Counters)
+	}
+	return results, nil
+}
+
+// localSources group the inputs by source, untagged inputs have no source
+func localSources(inputs []string) map[string][]FileRef {
+	sources := map[string][]FileRef{}
+	for _, input := range inputs {
+		source, fname, tagged := strings.Cut(input, "=")
+		if !tagged {
+			source, fname = "", input
+		}
+		sources[source] = append(sources[source], FileRef{Name: fname})
+	}
+	return sources
+}
+
+func fileNames(refs []FileRef) []string {
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.Name
+	}
+	return names
+}
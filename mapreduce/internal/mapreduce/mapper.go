@@ -2,59 +2,127 @@ package mapreduce
 
 import (
 	"fmt"
-	"log"
 	"strings"
+
+	"mapreduce/internal/tracing"
 )
 
+// MapImplementation split input lines into records and map them to pairs,
+// the pairs are encoded by the default codec unless it implements OutputCodecProvider
 type MapImplementation[K1, K2 comparable, V any] interface {
 	LineToRecords(line string) ([]K1, error)
 	Map(key K1) (Pair[K2, V], error)
 }
 
-type mapProcessor[K1, K2 comparable, V any] struct {
+// MapContextImplementation is a MapImplementation that receives the context of the task,
+// define its jobs with NewContextJob
+type MapContextImplementation[K1, K2 comparable, V any] interface {
+	LineToRecords(ctx *TaskContext, line string) ([]K1, error)
+	Map(ctx *TaskContext, key K1) (Pair[K2, V], error)
+}
+
+// mapperWithContext adapt a MapImplementation ignoring the context
+type mapperWithContext[K1, K2 comparable, V any] struct {
 	mapper MapImplementation[K1, K2, V]
 }
 
-func NewMapServer[K1, K2 comparable, V any](masterAddress string, m MapImplementation[K1, K2, V]) (*StageServer, error) {
-	mp := &mapProcessor[K1, K2, V]{
-		mapper: m,
+// ContextMapper adapt a MapImplementation to define jobs with NewContextJob
+func ContextMapper[K1, K2 comparable, V any](mapper MapImplementation[K1, K2, V]) MapContextImplementation[K1, K2, V] {
+	if mapper == nil {
+		return nil
 	}
-	srv, err := newStageServer("mapper", masterAddress, mp)
+	return &mapperWithContext[K1, K2, V]{mapper: mapper}
+}
+
+func (m *mapperWithContext[K1, K2, V]) LineToRecords(_ *TaskContext, line string) ([]K1, error) {
+	return m.mapper.LineToRecords(line)
+}
+
+func (m *mapperWithContext[K1, K2, V]) Map(_ *TaskContext, key K1) (Pair[K2, V], error) {
+	return m.mapper.Map(key)
+}
+
+func (m *mapperWithContext[K1, K2, V]) unwrap() any {
+	return m.mapper
+}
+
+// PartitionKeyProvider is implemented by mappers that choose the partition of a pair
+// by a part of its key, keys reduced together by a GroupComparator must have the
+// same partition key. By default the partition is chosen by the encoded key
+type PartitionKeyProvider[K comparable] interface {
+	PartitionKey(key K) string
+}
+
+type mapProcessor struct {
+	jobs map[string]Job
+}
+
+// NewMapServer create a mapper that runs the registered jobs with the given names,
+// up to slots tasks at the same time
+func NewMapServer(masterAddress string, slots int, jobs ...string) (*StageServer, error) {
+	registered, err := lookupJobs(jobs)
+	if err != nil {
+		return nil, err
+	}
+	mp := &mapProcessor{
+		jobs: registered,
+	}
+	srv, err := newStageServer("mapper", masterAddress, slots, jobs, mp)
 	if err != nil {
 		return nil, err
 	}
 	return srv, nil
 }
 
-func (m *mapProcessor[K1, K2, V]) Process(s *StageServer, cmd string, args ...string) string {
-	log.Printf("Process -> %s (%s)\n", cmd, strings.Join(args, " "))
+func (m *mapProcessor) Process(s *StageServer, cmd string, args ...string) string {
+	s.Logger().Debug("processing command", "command", cmd, "args", strings.Join(args, " "))
 	switch cmd {
 	case "map":
-		if len(args) != 2 {
-			return "invalid map command, expected 2 args\n"
+		if len(args) < 3 {
+			return "invalid map command, expected at least 3 args\n"
 		}
-		go m.runMap(s, args[0], args[1])
-		return fmt.Sprintf("ok mapping %s -> %s\n", args[0], args[1])
+		in, err := ParseFileRef(args[1])
+		if err != nil {
+			return fmt.Sprintf("invalid map command, %s\n", err)
+		}
+		conf, err := ParseJobConfig(args[3:])
+		if err != nil {
+			return fmt.Sprintf("invalid map command, %s\n", err)
+		}
+		job, ok := m.jobs[conf.Job]
+		if !ok {
+			return fmt.Sprintf("invalid map command, unsupported job %s\n", conf.Job)
+		}
+		go m.runMap(s, job, args[0], in, args[2], conf)
+		return fmt.Sprintf("ok mapping %s -> %s\n", in.Name, args[2])
 	case "ping":
 		return "pong\n"
 	}
 	return ""
 }
 
-func (m *mapProcessor[K1, K2, V]) runMap(s *StageServer, fnIn, fnOut string) {
-	log.Printf("running map in: %s, out: %s\n", fnIn, fnOut)
-	err := MapTextFile(fnIn, fnOut, m.mapper)
+func (m *mapProcessor) runMap(s *StageServer, job Job, taskId string, fnIn FileRef, outPrefix string, conf JobConfig) {
+	logger := s.Logger().With("job", job.Name(), "task", taskId)
+	logger.Info("running map", "in", fnIn.Name, "out", outPrefix)
+	var result TaskResult
+	task, done, err := s.startTask("map", taskId, conf)
+	if err == nil {
+		tracing.SpanFromContext(task).SetAttributes("in", fnIn.Name, "out", outPrefix)
+		result, err = job.runMap(task, fnIn, outPrefix)
+		result.Counters = task.Counters()
+		done(err)
+	}
 	if err != nil {
-		log.Printf("failed running map: %s\n", err)
-		err := s.Write("map error %s %s\n", s.Id(), err)
+		logger.Error("map failed", "err", err)
+		err := s.Write("map error %s %s\n", taskId, err)
 		if err != nil {
-			log.Printf("failed to notify master: %s\n", err)
+			logger.Error("failed to notify master", "err", err)
 		}
 		return
 	}
-	log.Println("mapping done, notify master")
-	err = s.Write("map done %s %s\n", s.Id(), fnOut)
+	logger.Info("map done")
+	err = s.Write("map done %s %s\n", taskId, result)
 	if err != nil {
-		log.Printf("failed to notify master: %s\n", err)
+		logger.Error("failed to notify master", "err", err)
 	}
 }
@@ -0,0 +1,33 @@
+package mapreduce
+
+import (
+	"io"
+
+	"mapreduce/internal/metrics"
+)
+
+// metrics of the tasks run by the workers and of the files they read and write,
+// registered in metrics.Default
+var (
+	workerTasksStarted   = metrics.NewCounter("mapreduce_worker_tasks_started_total", "Tasks started by the worker.", "stage")
+	workerTasksSucceeded = metrics.NewCounter("mapreduce_worker_tasks_succeeded_total", "Tasks finished without error by the worker.", "stage")
+	workerTasksFailed    = metrics.NewCounter("mapreduce_worker_tasks_failed_total", "Tasks failed or canceled in the worker.", "stage")
+	workerTaskDuration   = metrics.NewHistogram("mapreduce_worker_task_duration_seconds", "Duration of the tasks run by the worker.", metrics.DurationBuckets, "stage")
+
+	recordsRead    = metrics.NewCounter("mapreduce_records_read_total", "Lines and records read by the iterators.")
+	bytesRead      = metrics.NewCounter("mapreduce_read_bytes_total", "Bytes read from files, before decompression.")
+	recordsEmitted = metrics.NewCounter("mapreduce_records_emitted_total", "Lines and records written by the emitters.")
+	bytesEmitted   = metrics.NewCounter("mapreduce_emitted_bytes_total", "Bytes written to files, after compression.")
+)
+
+// countingReader count the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,80 @@
+package mapreduce
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Partitioner select the partition of an encoded key, every pair
+// with the same key must go to the same reducer
+type Partitioner interface {
+	Name() string
+	Partition(key string, parts int) int
+}
+
+const (
+	PartitionerHash        = "hash"
+	PartitionerFirstLetter = "first-letter"
+	PartitionerRange       = "range"
+)
+
+var partitioners = map[string]Partitioner{
+	PartitionerHash:        hashPartitioner{},
+	PartitionerFirstLetter: firstLetterPartitioner{},
+	PartitionerRange:       rangePartitioner{},
+}
+
+// DefaultPartitioner is used when a job does not specify one
+var DefaultPartitioner Partitioner = hashPartitioner{}
+
+// PartitionerByName return the partitioner registered with name
+func PartitionerByName(name string) (Partitioner, error) {
+	p, ok := partitioners[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown partitioner %s", name)
+	}
+	return p, nil
+}
+
+// hashPartitioner spread keys using fnv-1a
+type hashPartitioner struct{}
+
+func (hashPartitioner) Name() string {
+	return PartitionerHash
+}
+
+func (hashPartitioner) Partition(key string, parts int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(parts))
+}
+
+// firstLetterPartitioner group keys by their first byte,
+// keys starting with the same letter end in the same partition
+type firstLetterPartitioner struct{}
+
+func (firstLetterPartitioner) Name() string {
+	return PartitionerFirstLetter
+}
+
+func (firstLetterPartitioner) Partition(key string, parts int) int {
+	if len(key) == 0 {
+		return 0
+	}
+	return int(([]byte(key))[0]-'a') % parts
+}
+
+// rangePartitioner split the keys in ranges of their first byte, every key
+// in partition i sorts before the keys in partition i+1
+type rangePartitioner struct{}
+
+func (rangePartitioner) Name() string {
+	return PartitionerRange
+}
+
+func (rangePartitioner) Partition(key string, parts int) int {
+	if len(key) == 0 {
+		return 0
+	}
+	return int(key[0]) * parts / 256
+}
@@ -1,63 +1,149 @@
 package mapreduce
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"strings"
+
+	"mapreduce/internal/tracing"
 )
 
+// ReduceImplementation receives the intermediate pairs decoded by the codec of the job,
+// see InputCodecProvider to replace the default one
 type ReduceImplementation[K1, K2 comparable, V1, V2 any] interface {
-	LineToPair(line string) (Pair[K1, V1], error)
 	Reduce(key K1, values []V1) ([]Pair[K2, V2], error)
 }
 
-type reduceProcessor[K1, K2 comparable, V1, V2 any] struct {
+// ReduceContextImplementation is a ReduceImplementation that receives the context
+// of the task, define its jobs with NewContextJob
+type ReduceContextImplementation[K1, K2 comparable, V1, V2 any] interface {
+	Reduce(ctx *TaskContext, key K1, values []V1) ([]Pair[K2, V2], error)
+}
+
+// reducerWithContext adapt a ReduceImplementation ignoring the context
+type reducerWithContext[K1, K2 comparable, V1, V2 any] struct {
 	reducer ReduceImplementation[K1, K2, V1, V2]
 }
 
-func NewReduceServer[K1, K2 comparable, V1, V2 any](
-	masterAddress string,
-	r ReduceImplementation[K1, K2, V1, V2],
-) (*StageServer, error) {
-	rp := &reduceProcessor[K1, K2, V1, V2]{
-		reducer: r,
+// ContextReducer adapt a ReduceImplementation to define jobs with NewContextJob
+func ContextReducer[K1, K2 comparable, V1, V2 any](reducer ReduceImplementation[K1, K2, V1, V2]) ReduceContextImplementation[K1, K2, V1, V2] {
+	if reducer == nil {
+		return nil
 	}
-	srv, err := newStageServer("reducer", masterAddress, rp)
+	return &reducerWithContext[K1, K2, V1, V2]{reducer: reducer}
+}
+
+func (r *reducerWithContext[K1, K2, V1, V2]) Reduce(_ *TaskContext, key K1, values []V1) ([]Pair[K2, V2], error) {
+	return r.reducer.Reduce(key, values)
+}
+
+func (r *reducerWithContext[K1, K2, V1, V2]) unwrap() any {
+	return r.reducer
+}
+
+// Convergence is implemented by the reducers of iterative jobs, Delta measures
+// how much the output of a key changed from the previous iteration. The deltas
+// of every key are added and reported to the master, that stops iterating
+// when the total is below the epsilon of the job
+type Convergence[K1, K2 comparable, V1, V2 any] interface {
+	Delta(key K1, values []V1, output []Pair[K2, V2]) float64
+}
+
+// KeyComparator is implemented by reducers that sort their keys, Compare returns
+// a negative number when a goes before b, zero when they are equal and a positive
+// one otherwise. By default keys are sorted by their encoded form
+type KeyComparator[K comparable] interface {
+	Compare(a, b K) int
+}
+
+// GroupComparator is implemented by reducers that reduce different keys together,
+// consecutive keys in the order of Compare that are equal by GroupCompare are
+// reduced in a single call with the first key of the group and the values of all
+// of them, in key order. With a composite key it sorts the values by its secondary
+// part, the mapper must partition by the part compared, see PartitionKeyProvider
+type GroupComparator[K comparable] interface {
+	GroupCompare(a, b K) int
+}
+
+type reduceProcessor struct {
+	jobs map[string]Job
+}
+
+// NewReduceServer create a reducer that runs the registered jobs with the given names,
+// up to slots tasks at the same time
+func NewReduceServer(masterAddress string, slots int, jobs ...string) (*StageServer, error) {
+	registered, err := lookupJobs(jobs)
+	if err != nil {
+		return nil, err
+	}
+	rp := &reduceProcessor{
+		jobs: registered,
+	}
+	srv, err := newStageServer("reducer", masterAddress, slots, jobs, rp)
 	if err != nil {
 		return nil, err
 	}
 	return srv, nil
 }
 
-func (r *reduceProcessor[K1, K2, V1, V2]) Process(s *StageServer, cmd string, args ...string) string {
-	log.Printf("Process -> %s (%s)\n", cmd, strings.Join(args, " "))
+func (r *reduceProcessor) Process(s *StageServer, cmd string, args ...string) string {
+	s.Logger().Debug("processing command", "command", cmd, "args", strings.Join(args, " "))
 	switch cmd {
 	case "reduce":
-		if len(args) != 2 {
-			return "invalid reduce command, expected 2 args\n"
+		if len(args) < 3 {
+			return "invalid reduce command, expected at least 3 args\n"
+		}
+		in, err := ParseFileRefs(args[1])
+		if err != nil {
+			return fmt.Sprintf("invalid reduce command, %s\n", err)
 		}
-		go r.runReduce(s, args[0], args[1])
-		return fmt.Sprintf("ok reducing %s -> %s\n", args[0], args[1])
+		conf, err := ParseJobConfig(args[3:])
+		if err != nil {
+			return fmt.Sprintf("invalid reduce command, %s\n", err)
+		}
+		job, ok := r.jobs[conf.Job]
+		if !ok {
+			return fmt.Sprintf("invalid reduce command, unsupported job %s\n", conf.Job)
+		}
+		go r.runReduce(s, job, args[0], in, args[2], conf)
+		return fmt.Sprintf("ok reducing %d files -> %s\n", len(in), args[2])
 	case "ping":
 		return "pong\n"
 	}
 	return ""
 }
 
-func (r *reduceProcessor[K1, K2, V1, V2]) runReduce(s *StageServer, fnIn, fnOut string) {
-	log.Printf("running reduce in: %s, out: %s\n", fnIn, fnOut)
-	err := ReduceTextFile(fnIn, fnOut, r.reducer)
+func (r *reduceProcessor) runReduce(s *StageServer, job Job, taskId string, fnIn []FileRef, fnOut string, conf JobConfig) {
+	logger := s.Logger().With("job", job.Name(), "task", taskId)
+	logger.Info("running reduce", "in", len(fnIn), "out", fnOut)
+	var result TaskResult
+	task, done, err := s.startTask("reduce", taskId, conf)
+	if err == nil {
+		tracing.SpanFromContext(task).SetAttributes("in", len(fnIn), "out", fnOut)
+		result, err = job.runReduce(task, fnIn, fnOut)
+		result.Counters = task.Counters()
+		done(err)
+	}
+	var corrupt *CorruptFileError
+	if errors.As(err, &corrupt) {
+		logger.Error("corrupt partition", "file", corrupt.Name, "err", err)
+		err := s.Write("reduce corrupt %s %s %s\n", taskId, corrupt.Name, err)
+		if err != nil {
+			logger.Error("failed to notify master", "err", err)
+		}
+		return
+	}
 	if err != nil {
-		log.Printf("failed running reduce: %s\n", err)
-		err := s.Write("reduce error %s %s\n", s.Id(), err)
+		logger.Error("reduce failed", "err", err)
+		err := s.Write("reduce error %s %s\n", taskId, err)
 		if err != nil {
-			log.Printf("failed to notify master: %s\n", err)
+			logger.Error("failed to notify master", "err", err)
 		}
 		return
 	}
-	log.Println("reduce done, notify master")
-	err = s.Write("reduce done %s %s\n", s.Id(), fnOut)
+	logger.Info("reduce done")
+	err = s.Write("reduce done %s %s\n", taskId, result)
 	if err != nil {
-		log.Printf("failed to notify master: %s\n", err)
+		logger.Error("failed to notify master", "err", err)
 	}
 }
@@ -1,17 +1,20 @@
 package mapreduce
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	n "mapreduce/internal/network"
+	"mapreduce/internal/tracing"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	msgRegister = "register %s\n"
+	msgRegister = "register %s %s %d\n"
 	msgAccepted = "%s accepted"
 )
 
@@ -20,27 +23,64 @@ type StageProcessor interface {
 }
 
 type StageServer struct {
+	// Exporter receive the spans of the tasks, set it before Run,
+	// the spans are not exported when it is nil
+	Exporter tracing.Exporter
+	// Transport Run connects to the master through, set it before Run,
+	// n.DefaultTransport when it is nil
+	Transport n.Transport
 	label     string
+	address   string
+	jobs      []string
 	id        string
 	master    n.Connection
 	processor StageProcessor
 	mu        sync.Mutex
+	// ctx is canceled when the connection with the master is lost
+	ctx     context.Context
+	cancel  context.CancelFunc
+	tasksMu sync.Mutex
+	running map[string]*runningTask
+	// slots limit the tasks run at the same time, the others wait for a free one
+	slots chan struct{}
 }
 
-func newStageServer(label, masterAddress string, processor StageProcessor) (*StageServer, error) {
-	c, err := n.NewClient(masterAddress)
-	if err != nil {
-		return nil, err
+// runningTask can be canceled by the master
+type runningTask struct {
+	cancel context.CancelFunc
+}
+
+func newStageServer(label, masterAddress string, slots int, jobs []string, processor StageProcessor) (*StageServer, error) {
+	if len(jobs) == 0 {
+		return nil, errors.New("a worker must support at least one job")
 	}
+	if slots < 1 {
+		return nil, fmt.Errorf("invalid slots %d, a worker must run at least one task", slots)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &StageServer{
 		label:     label,
-		master:    c,
+		jobs:      jobs,
+		address:   masterAddress,
 		processor: processor,
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   map[string]*runningTask{},
+		slots:     make(chan struct{}, slots),
 	}, nil
 }
 
+// Run connect to the master, register the worker and process the commands of
+// the master until the connection is closed
 func (s *StageServer) Run() error {
-	err := s.register()
+	c, err := n.NewClient(s.address, n.WithTransport(s.Transport))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.master = c
+	s.mu.Unlock()
+	err = s.register()
 	if err != nil {
 		return err
 	}
@@ -48,7 +88,7 @@ func (s *StageServer) Run() error {
 }
 
 func (s *StageServer) register() error {
-	_, err := s.master.Write(msgRegister, s.label)
+	_, err := s.master.Write(msgRegister, s.label, strings.Join(s.jobs, ","), cap(s.slots))
 	if err != nil {
 		return err
 	}
@@ -65,21 +105,22 @@ func (s *StageServer) register() error {
 }
 
 func (s *StageServer) run() error {
+	defer s.cancel()
+	s.Logger().Info("registered")
 	for {
 		msg, err := s.master.Read()
 		if err != nil {
-			log.Println("error reading from master", err)
 			if err == io.EOF {
-				log.Println("connection closed")
-				return err
+				s.Logger().Info("connection with master closed")
+			} else {
+				s.Logger().Error("error reading from master", "err", err)
 			}
-			continue
+			return err
 		}
-		err = s.Write(s.process(msg))
+		err = s.Write("%s", s.process(msg))
 		if err != nil {
-			log.Println("error writing response to master", err)
+			s.Logger().Error("error writing response to master", "err", err)
 			if err == io.EOF {
-				log.Println("connection closed")
 				return err
 			}
 		}
@@ -93,13 +134,107 @@ func (s *StageServer) process(msg string) string {
 	}
 	cmd := strings.ToLower(split[0])
 	args := split[1:]
+	if cmd == "cancel" && len(args) == 1 {
+		s.cancelTask(args[0])
+		return fmt.Sprintf("ok cancel %s\n", args[0])
+	}
 	return s.processor.Process(s, cmd, args...)
 }
 
+// startTask wait for a free slot and create the context of a task, with the span
+// of the worker for the task, child of the span of the master in conf.
+// Call done with the error of the task when it ends. A task waiting for a slot can be canceled
+func (s *StageServer) startTask(stage, taskId string, conf JobConfig) (task *TaskContext, done func(error), err error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	t := &runningTask{cancel: cancel}
+	s.tasksMu.Lock()
+	s.running[taskId] = t
+	s.tasksMu.Unlock()
+	remove := func() {
+		s.tasksMu.Lock()
+		if s.running[taskId] == t {
+			delete(s.running, taskId)
+		}
+		s.tasksMu.Unlock()
+		cancel()
+	}
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		remove()
+		return nil, nil, ctx.Err()
+	}
+	report := func(progress float64) {
+		err := s.Write("%s progress %s %.2f\n", stage, taskId, progress)
+		if err != nil {
+			s.Logger().Warn("failed to report progress", "task", taskId, "err", err)
+		}
+	}
+	started := time.Now()
+	workerTasksStarted.Inc(stage)
+	span := tracing.NewSpan(s.Exporter, conf.Trace, s.name(), stage+" "+taskId, tracing.KindServer)
+	span.SetAttributes("job", conf.Job, "task", taskId)
+	task, err = newTaskContext(tracing.ContextWithSpan(ctx, span), s.Logger(), taskId, conf, report)
+	if err != nil {
+		span.End(err)
+		workerTasksFailed.Inc(stage)
+		<-s.slots
+		remove()
+		return nil, nil, err
+	}
+	done = func(err error) {
+		for name, v := range task.Counters() {
+			span.SetAttributes("counter."+name, v)
+		}
+		span.End(err)
+		workerTaskDuration.Observe(time.Since(started).Seconds(), stage)
+		if err != nil {
+			workerTasksFailed.Inc(stage)
+		} else {
+			workerTasksSucceeded.Inc(stage)
+		}
+		<-s.slots
+		remove()
+	}
+	return task, done, nil
+}
+
+// cancelTask stop a running task, its Map or Reduce returns the error of the context
+func (s *StageServer) cancelTask(taskId string) {
+	s.tasksMu.Lock()
+	defer s.tasksMu.Unlock()
+	if t, ok := s.running[taskId]; ok {
+		s.Logger().Info("canceling task", "task", taskId)
+		t.cancel()
+	}
+}
+
+// Close the connection with the master, Run returns and the running tasks are canceled
+func (s *StageServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.master != nil {
+		s.master.Close()
+	}
+}
+
 func (s *StageServer) Id() string {
 	return s.id
 }
 
+// Logger of the worker, with its kind and id once registered, mapper 0
+func (s *StageServer) Logger() *slog.Logger {
+	return slog.Default().With("worker", s.name())
+}
+
+// name of the worker, its kind and id once registered
+func (s *StageServer) name() string {
+	if s.id == "" {
+		return s.label
+	}
+	return s.label + " " + s.id
+}
+
 func (s *StageServer) Write(msg string, args ...any) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
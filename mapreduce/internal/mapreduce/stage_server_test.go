@@ -0,0 +1,71 @@
+package mapreduce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type started struct {
+	done func(error)
+	err  error
+}
+
+// startAsync start a task on its own goroutine, its result is received once it has a slot
+func startAsync(s *StageServer, taskId string) <-chan started {
+	ch := make(chan started, 1)
+	go func() {
+		_, done, err := s.startTask("map", taskId, DefaultJobConfig())
+		ch <- started{done: done, err: err}
+	}()
+	return ch
+}
+
+// waiting fail the test if the task got a slot
+func waiting(t *testing.T, ch <-chan started, taskId string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("%s started without a free slot", taskId)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestStartTaskWaitsForSlot(t *testing.T) {
+	s, err := newStageServer("mapper", "", 2, []string{"wordcount"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var running []started
+	for _, id := range []string{"m-0", "m-1"} {
+		r := <-startAsync(s, id)
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		running = append(running, r)
+	}
+	third := startAsync(s, "m-2")
+	waiting(t, third, "m-2")
+	fourth := startAsync(s, "m-3")
+	waiting(t, fourth, "m-3")
+
+	// a task waiting for a slot can be canceled
+	s.cancelTask("m-3")
+	if r := <-fourth; !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("canceled task returned %v", r.err)
+	}
+	running[0].done(nil)
+	r := <-third
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	running[1].done(nil)
+	r.done(nil)
+	if n := len(s.slots); n != 0 {
+		t.Errorf("%d slots still taken", n)
+	}
+	if n := len(s.running); n != 0 {
+		t.Errorf("%d tasks still running", n)
+	}
+}
@@ -0,0 +1,195 @@
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskContext describe the map or reduce task running a job, jobs created
+// with NewTaskJob get it to read the side files and parameters attached
+// to the job by the submitter and implementations of MapContextImplementation
+// and ReduceContextImplementation get it on every call. It is done when the
+// master cancels the task or the worker loses its connection
+type TaskContext struct {
+	context.Context
+	// Task is the id given by the master, m-0, r-1...
+	Task   string
+	Config JobConfig
+
+	mu           sync.Mutex
+	counters     map[string]int64
+	report       func(done float64)
+	lastProgress time.Time
+	logger       *slog.Logger
+}
+
+// Counters of the records read and written by every task
+const (
+	CounterMapInputLines       = "map.input.lines"
+	CounterMapOutputRecords    = "map.output.records"
+	CounterReduceInputRecords  = "reduce.input.records"
+	CounterReduceInputGroups   = "reduce.input.groups"
+	CounterReduceOutputRecords = "reduce.output.records"
+)
+
+// ProgressInterval is the minimum time between two progress reports of a task
+var ProgressInterval = time.Second
+
+// SideFileCacheDir is where the workers keep a copy of the side files of
+// the jobs, named by their checksum so they are copied once
+var SideFileCacheDir = filepath.Join(os.TempDir(), "mapreduce-cache")
+
+// newTaskContext copy the side files of the job to the cache of the worker,
+// the context refers to the copies. Report send the progress of the task
+func newTaskContext(ctx context.Context, logger *slog.Logger, task string, conf JobConfig, report func(done float64)) (*TaskContext, error) {
+	files := make(map[string]FileRef, len(conf.Files))
+	for name, ref := range conf.Files {
+		local, err := localizeSideFile(ref)
+		if err != nil {
+			return nil, fmt.Errorf("side file %s: %w", name, err)
+		}
+		files[name] = local
+	}
+	conf.Files = files
+	return taskContext(ctx, logger, task, conf, report), nil
+}
+
+// taskContext log with the job and the task as attributes of logger
+func taskContext(ctx context.Context, logger *slog.Logger, task string, conf JobConfig, report func(done float64)) *TaskContext {
+	return &TaskContext{
+		Context:  ctx,
+		Task:     task,
+		Config:   conf,
+		counters: map[string]int64{},
+		report:   report,
+		logger:   logger.With("job", conf.Job, "task", task),
+	}
+}
+
+// localTaskContext is the context of tasks run without a master,
+// side files are read in place
+func localTaskContext(task string, conf JobConfig) *TaskContext {
+	return taskContext(context.Background(), slog.Default(), task, conf, nil)
+}
+
+// IncrCounter add delta to the counter with name, the counters are added by the
+// master for each stage and for the job. Spaces and = in the name are replaced by _
+func (t *TaskContext) IncrCounter(name string, delta int64) {
+	name = counterReplacer.Replace(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counters[name] += delta
+}
+
+var counterReplacer = strings.NewReplacer(" ", "_", "\t", "_", "=", "_", ",", "_")
+
+// Counters return a copy of the counters of the task
+func (t *TaskContext) Counters() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counters := make(map[string]int64, len(t.counters))
+	for name, v := range t.counters {
+		counters[name] = v
+	}
+	return counters
+}
+
+// Progress report the fraction of the task done, between 0 and 1. Reports
+// are sent to the master at most once every ProgressInterval, mappers
+// and reducers report their progress even when the job does not
+func (t *TaskContext) Progress(done float64) {
+	t.mu.Lock()
+	if t.report == nil || time.Since(t.lastProgress) < ProgressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastProgress = time.Now()
+	t.mu.Unlock()
+	t.report(done)
+}
+
+// Log write an informational message formatted as fmt.Sprintf,
+// with the job and the task as attributes
+func (t *TaskContext) Log(format string, args ...any) {
+	t.logger.Info(fmt.Sprintf(format, args...))
+}
+
+// Logger return the structured logger of the task, with the job,
+// the task and the worker running it as attributes
+func (t *TaskContext) Logger() *slog.Logger {
+	return t.logger
+}
+
+// Param return the value of the param.<name> option of the job
+func (t *TaskContext) Param(name string) (string, bool) {
+	v, ok := t.Config.Params[name]
+	return v, ok
+}
+
+// SideFile return the path of the copy of the file.<name> option of the job
+func (t *TaskContext) SideFile(name string) (string, error) {
+	ref, ok := t.Config.Files[name]
+	if !ok {
+		return "", fmt.Errorf("job %s has no side file %s", t.Config.Job, name)
+	}
+	return ref.Name, nil
+}
+
+// OpenSideFile open the side file with name, decompressing it if needed
+func (t *TaskContext) OpenSideFile(name string) (io.ReadCloser, error) {
+	fname, err := t.SideFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(fname)
+}
+
+// localizeSideFile verify ref and copy it to the cache, files without checksum are used in place
+func localizeSideFile(ref FileRef) (FileRef, error) {
+	if ref.Checksum == nil {
+		return ref, nil
+	}
+	cached := filepath.Join(SideFileCacheDir, fmt.Sprintf("%08x-%d-%s", ref.Checksum.CRC, ref.Checksum.Records, filepath.Base(ref.Name)))
+	local := FileRef{Name: cached, Checksum: ref.Checksum}
+	if _, err := os.Stat(cached); err == nil {
+		return local, nil
+	}
+	err := VerifyTextFile(ref)
+	if err != nil {
+		return ref, err
+	}
+	err = os.MkdirAll(SideFileCacheDir, 0o755)
+	if err != nil {
+		return ref, err
+	}
+	in, err := os.Open(ref.Name)
+	if err != nil {
+		return ref, err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(SideFileCacheDir, "copy-*")
+	if err != nil {
+		return ref, err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), cached)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return ref, err
+	}
+	return local, nil
+}
@@ -0,0 +1,99 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TaskResult is what a worker reports to the master when a task is done,
+// encoded as the list of files followed by key=value options
+type TaskResult struct {
+	Files []FileRef
+	// Delta is the convergence of an iterative job, the sum of the deltas of every key
+	// reduced by the task, nil when the reducer does not implement Convergence
+	Delta *float64
+	// Counters of the task, the ones incremented by the job and
+	// the records read and written, encoded as counter.<name>=value
+	Counters map[string]int64
+}
+
+func (r TaskResult) String() string {
+	s := FormatFileRefs(r.Files)
+	if r.Delta != nil {
+		s += " delta=" + strconv.FormatFloat(*r.Delta, 'g', -1, 64)
+	}
+	for _, name := range CounterNames(r.Counters) {
+		s += fmt.Sprintf(" counter.%s=%d", name, r.Counters[name])
+	}
+	return s
+}
+
+// CounterNames return the names of the counters sorted
+func CounterNames(counters map[string]int64) []string {
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatCounters write the counters as name=value separated by spaces
+func FormatCounters(counters map[string]int64) string {
+	fields := make([]string, 0, len(counters))
+	for _, name := range CounterNames(counters) {
+		fields = append(fields, fmt.Sprintf("%s=%d", name, counters[name]))
+	}
+	return strings.Join(fields, " ")
+}
+
+// AddCounters add the counters of from to to, creating it when nil
+func AddCounters(to, from map[string]int64) map[string]int64 {
+	if to == nil && len(from) > 0 {
+		to = make(map[string]int64, len(from))
+	}
+	for name, v := range from {
+		to[name] += v
+	}
+	return to
+}
+
+// ParseTaskResult read a result written by TaskResult.String split in fields
+func ParseTaskResult(args []string) (TaskResult, error) {
+	var r TaskResult
+	if len(args) == 0 {
+		return r, fmt.Errorf("invalid task result, expected files")
+	}
+	files, err := ParseFileRefs(args[0])
+	if err != nil {
+		return r, err
+	}
+	r.Files = files
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid task result option %s, expected key=value", arg)
+		}
+		if name, ok := strings.CutPrefix(key, "counter."); ok {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return r, fmt.Errorf("invalid counter %s=%s", name, value)
+			}
+			r.Counters = AddCounters(r.Counters, map[string]int64{name: v})
+			continue
+		}
+		switch key {
+		case "delta":
+			delta, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return r, fmt.Errorf("invalid delta %s", value)
+			}
+			r.Delta = &delta
+		default:
+			return r, fmt.Errorf("unknown task result option %s", key)
+		}
+	}
+	return r, nil
+}
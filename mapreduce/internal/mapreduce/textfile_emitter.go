@@ -3,48 +3,171 @@ package mapreduce
 import (
 	"bufio"
 	"fmt"
-	"os"
 )
 
-type textFileEmitterForMapper[K comparable, V any] struct {
-	file   *os.File
-	writer *bufio.Writer
+type recordFileEmitter[K comparable, V any] struct {
+	file    *outputFile
+	writer  RecordWriter
+	codec   Codec[K, V]
+	records int
 }
 
-// NewTextFileEmitterForMapper create en emiter for text files
-func newTextFileEmitterForMapper[K comparable, V any](fname string) (Emitter[Pair[K, V]], error) {
-	file, err := os.Create(fname)
+// newRecordFileEmitter create an emitter of pairs encoded with codec and format
+func newRecordFileEmitter[K comparable, V any](
+	fname string,
+	format OutputFormat,
+	compression Compression,
+	codec Codec[K, V],
+) (*recordFileEmitter[K, V], error) {
+	file, err := createFile(fname, compression)
 	if err != nil {
 		return nil, err
 	}
-	writer := bufio.NewWriter(file)
-	return &textFileEmitterForMapper[K, V]{
+	return recordFileEmitterFor(file, format, codec), nil
+}
+
+func recordFileEmitterFor[K comparable, V any](file *outputFile, format OutputFormat, codec Codec[K, V]) *recordFileEmitter[K, V] {
+	return &recordFileEmitter[K, V]{
 		file:   file,
-		writer: writer,
-	}, nil
+		writer: format.NewWriter(file),
+		codec:  codec,
+	}
 }
 
-func (t *textFileEmitterForMapper[K, V]) Close() error {
-	t.writer.Flush()
+func (t *recordFileEmitter[K, V]) Close() error {
+	err := t.writer.Flush()
+	if err != nil {
+		t.file.Close()
+		return err
+	}
+	recordsEmitted.Add(float64(t.records))
 	return t.file.Close()
 }
 
-func (t *textFileEmitterForMapper[K, V]) Emit(value Pair[K, V]) error {
-	_, err := t.writer.WriteString(fmt.Sprintf("%s\n", value))
+// abort discard the file without flushing it
+func (t *recordFileEmitter[K, V]) abort() {
+	t.file.abort()
+}
+
+func (t *recordFileEmitter[K, V]) Emit(value Pair[K, V]) error {
+	key, v, err := t.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return t.emitRecord(key, v)
+}
+
+// emitRecord write an already encoded pair
+func (t *recordFileEmitter[K, V]) emitRecord(key, value string) error {
+	err := t.writer.Write(key, value)
+	if err != nil {
+		return err
+	}
+	t.records++
+	return nil
+}
+
+func (t *recordFileEmitter[K, V]) Checksum() Checksum {
+	return Checksum{CRC: t.file.crc(), Records: t.records}
+}
+
+// partitionedEmitter encode pairs and write each one to the partition of its key
+type partitionedEmitter[K comparable, V any] struct {
+	codec        Codec[K, V]
+	partitioner  Partitioner
+	partitionKey PartitionKeyProvider[K]
+	parts        []*recordFileEmitter[string, string]
+	names        []string
+}
+
+func (p *partitionedEmitter[K, V]) Emit(value Pair[K, V]) error {
+	key, v, err := p.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	partitionKey := key
+	if p.partitionKey != nil {
+		partitionKey = p.partitionKey.PartitionKey(value.Key)
+	}
+	i := p.partitioner.Partition(partitionKey, len(p.parts))
+	return p.parts[i].emitRecord(key, v)
+}
+
+func (p *partitionedEmitter[K, V]) Close() (err error) {
+	for i, part := range p.parts {
+		e := part.Close()
+		if e != nil && err == nil {
+			err = fmt.Errorf("closing %s: %w", p.names[i], e)
+		}
+	}
 	return err
 }
 
+// abort remove the partitions written, for a task that failed
+func (p *partitionedEmitter[K, V]) abort() {
+	for _, part := range p.parts {
+		part.abort()
+	}
+}
+
+// Files return the partitions with their checksums, only valid after Close
+func (p *partitionedEmitter[K, V]) Files() []FileRef {
+	refs := make([]FileRef, len(p.parts))
+	for i, part := range p.parts {
+		sum := part.Checksum()
+		refs[i] = FileRef{Name: p.names[i], Checksum: &sum}
+	}
+	return refs
+}
+
+// newTextFileEmitterForMapper create en emiter for the intermediate files,
+// a file named prefix-N.txt is written for each partition of the job.
+// The outputs of map only jobs are compressed as the outputs of reducers
+func newTextFileEmitterForMapper[K comparable, V any](
+	prefix string,
+	conf JobConfig,
+	codec Codec[K, V],
+	partitionKey PartitionKeyProvider[K],
+) (*partitionedEmitter[K, V], error) {
+	p := &partitionedEmitter[K, V]{
+		codec:        codec,
+		partitioner:  conf.Partitioner,
+		partitionKey: partitionKey,
+	}
+	compression := conf.Compression
+	if conf.Mode == ModeMapOnly {
+		compression = conf.OutputCompression
+	}
+	for i := 0; i < conf.Partitions; i++ {
+		name := fmt.Sprintf("%s-%d.txt", prefix, i)
+		part, err := newRecordFileEmitter(name, conf.Format, compression, DefaultCodec[string, string]())
+		if err != nil {
+			p.abort()
+			return nil, err
+		}
+		p.parts = append(p.parts, part)
+		p.names = append(p.names, name)
+	}
+	return p, nil
+}
+
+// NewRecordFileEmitter create an emitter of raw key/value records
+func NewRecordFileEmitter(fname string, format OutputFormat, compression Compression) (Emitter[Pair[string, string]], error) {
+	return newRecordFileEmitter(fname, format, compression, DefaultCodec[string, string]())
+}
+
 func (p Pair[K, V]) String() string {
 	return fmt.Sprintf(`%v,%v`, p.Key, p.Value)
 }
 
 type textFileLineEmitter struct {
-	file   *os.File
+	file   *outputFile
 	writer *bufio.Writer
+	lines  int
 }
 
 func NewTextFileLineEmitter(fname string) (Emitter[string], error) {
-	file, err := os.Create(fname)
+	file, err := createFile(fname, noCompression{})
 	if err != nil {
 		return nil, err
 	}
@@ -56,42 +179,68 @@ func NewTextFileLineEmitter(fname string) (Emitter[string], error) {
 }
 
 func (t *textFileLineEmitter) Close() error {
-	t.writer.Flush()
+	err := t.writer.Flush()
+	if err != nil {
+		t.file.Close()
+		return err
+	}
+	recordsEmitted.Add(float64(t.lines))
 	return t.file.Close()
 }
 
 func (t *textFileLineEmitter) Emit(value string) error {
 	_, err := t.writer.WriteString(fmt.Sprintf("%s\n", value))
-	return err
+	if err != nil {
+		return err
+	}
+	t.lines++
+	return nil
+}
+
+func (t *textFileLineEmitter) Checksum() Checksum {
+	return Checksum{CRC: t.file.crc(), Records: t.lines}
 }
 
 type textFileEmitterForReducer[K comparable, V any] struct {
-	file   *os.File
-	writer *bufio.Writer
+	records *recordFileEmitter[K, V]
 }
 
+// Emit write every pair returned by a reduce as its own record
 func (t *textFileEmitterForReducer[K, V]) Emit(v []Pair[K, V]) error {
-	if len(v) == 1 {
-		_, err := t.writer.WriteString(fmt.Sprintf("%s\n", v[0]))
-		return err
+	for _, p := range v {
+		err := t.records.Emit(p)
+		if err != nil {
+			return err
+		}
 	}
-	_, err := t.writer.WriteString(fmt.Sprintf("%s\n", v))
-	return err
+	return nil
 }
 
 func (t *textFileEmitterForReducer[K, V]) Close() error {
-	t.writer.Flush()
-	return t.file.Close()
+	return t.records.Close()
+}
+
+func (t *textFileEmitterForReducer[K, V]) Checksum() Checksum {
+	return t.records.Checksum()
+}
+
+// abort discard the output, the previous content of the file is kept
+func (t *textFileEmitterForReducer[K, V]) abort() {
+	t.records.file.abort()
 }
 
-func newTextFileEmitterForReducer[K comparable, V any](fname string) (Emitter[[]Pair[K, V]], error) {
-	file, err := os.Create(fname)
+// newTextFileEmitterForReducer create an emitter for the final output of a job,
+// the file is replaced only when the emitter is closed without errors
+func newTextFileEmitterForReducer[K comparable, V any](
+	fname string,
+	conf JobConfig,
+	codec Codec[K, V],
+) (*textFileEmitterForReducer[K, V], error) {
+	file, err := createFileAtomic(fname, conf.OutputCompression)
 	if err != nil {
 		return nil, err
 	}
-	writer := bufio.NewWriter(file)
 	return &textFileEmitterForReducer[K, V]{
-		file:   file,
-		writer: writer,
+		records: recordFileEmitterFor(file, conf.Format, codec),
 	}, nil
 }
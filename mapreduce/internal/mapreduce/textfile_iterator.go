@@ -2,21 +2,29 @@ package mapreduce
 
 import (
 	"bufio"
-	"log"
-	"os"
+	"errors"
+	"io"
+	"log/slog"
 )
 
 type textFileIterator struct {
-	file    *os.File
+	file    io.ReadCloser
 	scanner *bufio.Scanner
+	lines   int
 }
 
 func (t *textFileIterator) Close() error {
+	recordsRead.Add(float64(t.lines))
+	t.lines = 0
 	return t.file.Close()
 }
 
 func (t *textFileIterator) Next() bool {
-	return t.scanner.Scan()
+	if !t.scanner.Scan() {
+		return false
+	}
+	t.lines++
+	return true
 }
 
 func (t *textFileIterator) Value() string {
@@ -27,8 +35,9 @@ func (t *textFileIterator) Error() error {
 	return t.scanner.Err()
 }
 
+// NewTextFileIterator iterate over the lines of a file, compressed files are detected
 func NewTextFileIterator(fname string) (Iterator[string], error) {
-	file, err := os.Open(fname)
+	file, err := openFile(fname)
 	if err != nil {
 		return nil, err
 	}
@@ -39,11 +48,76 @@ func NewTextFileIterator(fname string) (Iterator[string], error) {
 	}, nil
 }
 
-func OpenTextFileLineIterators(
+type recordFileIterator struct {
+	file   io.ReadCloser
+	reader RecordReader
+	value  Pair[string, string]
+	err    error
+	// skip is called with the bad records of the reader, reading goes on
+	// when it returns nil. Without it a bad record stops the iterator
+	skip func(line int, record string, err error) error
+	// records read, bad ones included
+	records int
+}
+
+// NewRecordFileIterator iterate over the key/value records of a file encoded with format,
+// compressed files are detected
+func NewRecordFileIterator(fname string, format OutputFormat) (Iterator[Pair[string, string]], error) {
+	file, err := openFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &recordFileIterator{
+		file:   file,
+		reader: format.NewReader(file),
+	}, nil
+}
+
+func (t *recordFileIterator) Close() error {
+	recordsRead.Add(float64(t.records))
+	t.records = 0
+	return t.file.Close()
+}
+
+func (t *recordFileIterator) Next() bool {
+	if t.err != nil {
+		return false
+	}
+	key, value, err := t.reader.Read()
+	var bad *BadRecordError
+	for t.skip != nil && errors.As(err, &bad) {
+		t.records++
+		if err = t.skip(bad.Line, bad.Record, bad.Err); err != nil {
+			t.err = err
+			return false
+		}
+		key, value, err = t.reader.Read()
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			t.err = err
+		}
+		return false
+	}
+	t.records++
+	t.value = Pair[string, string]{Key: key, Value: value}
+	return true
+}
+
+func (t *recordFileIterator) Value() Pair[string, string] {
+	return t.value
+}
+
+func (t *recordFileIterator) Error() error {
+	return t.err
+}
+
+func OpenRecordFileIterators(
 	fnames []string,
-) (iterators []Iterator[string], err error) {
+	format OutputFormat,
+) (iterators []Iterator[Pair[string, string]], err error) {
 	for _, name := range fnames {
-		iter, err := NewTextFileIterator(name)
+		iter, err := NewRecordFileIterator(name, format)
 		if err != nil {
 			return nil, err
 		}
@@ -52,13 +126,30 @@ func OpenTextFileLineIterators(
 	return
 }
 
-func CloseTextFileLineIterators(iters []Iterator[string], names []string) error {
+func CloseIterators[V any](iters []Iterator[V], names []string) error {
 	for i := 0; i < len(iters); i++ {
 		err := iters[i].Close()
 		if err != nil {
-			log.Printf("error closing %s: %v\n", names[i], err)
+			slog.Warn("error closing file", "file", names[i], "err", err)
 			return err
 		}
 	}
 	return nil
 }
+
+func OpenTextFileLineIterators(
+	fnames []string,
+) (iterators []Iterator[string], err error) {
+	for _, name := range fnames {
+		iter, err := NewTextFileIterator(name)
+		if err != nil {
+			return nil, err
+		}
+		iterators = append(iterators, iter)
+	}
+	return
+}
+
+func CloseTextFileLineIterators(iters []Iterator[string], names []string) error {
+	return CloseIterators(iters, names)
+}
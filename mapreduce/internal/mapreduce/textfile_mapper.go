@@ -1,52 +1,196 @@
 package mapreduce
 
+import "sync"
+
+// mapBatchSize is the number of lines read before mapping them concurrently
+const mapBatchSize = 1024
+
 type textFileMapper[K1, K2 comparable, V any] struct {
-	mapper MapImplementation[K1, K2, V]
+	mapper MapContextImplementation[K1, K2, V]
+	ctx    *TaskContext
+	// lines of the input when known, to report the progress
+	lines int
+	// name of the input and the lines skipped mapping it
+	source string
+	bad    *badRecords
+	// workers mapping the lines of a batch, see JobConfig.MapWorkers
+	workers int
+	read    int
+	emitted int
+}
+
+// mappedLine are the pairs of a line and the errors of its records that failed
+type mappedLine[K2 comparable, V any] struct {
+	line  string
+	pairs []Pair[K2, V]
+	errs  []error
 }
 
 // NewTextFileMapper return a file mapper to a emmitter of pairs
-func newTextFileMapper[K1, K2 comparable, V any](mapper MapImplementation[K1, K2, V]) *textFileMapper[K1, K2, V] {
+func newTextFileMapper[K1, K2 comparable, V any](ctx *TaskContext, mapper MapContextImplementation[K1, K2, V]) *textFileMapper[K1, K2, V] {
 	return &textFileMapper[K1, K2, V]{
-		mapper: mapper,
+		mapper:  mapper,
+		ctx:     ctx,
+		workers: ctx.Config.MapWorkers,
 	}
 }
 
+// Map every line of in, with several workers the lines are mapped
+// concurrently but the pairs are emitted in the order of the input
 func (t *textFileMapper[K1, K2, V]) Map(in Iterator[string], out Emitter[Pair[K2, V]]) error {
-	for in.Next() {
-		line := in.Value()
-		records, err := t.mapper.LineToRecords(line)
-		if err != nil {
+	t.read, t.emitted = 0, 0
+	defer func() {
+		t.ctx.IncrCounter(CounterMapInputLines, int64(t.read))
+		t.ctx.IncrCounter(CounterMapOutputRecords, int64(t.emitted))
+	}()
+	var err error
+	if t.workers > 1 {
+		err = t.mapConcurrently(in, out)
+	} else {
+		for err == nil && in.Next() {
+			if err = t.ctx.Err(); err == nil {
+				err = t.emit(t.mapLine(in.Value()), out)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := in.Error(); err != nil {
+		return err
+	}
+	return t.bad.check(t.read)
+}
+
+// mapConcurrently read the input in batches, each worker maps a share of the lines
+func (t *textFileMapper[K1, K2, V]) mapConcurrently(in Iterator[string], out Emitter[Pair[K2, V]]) error {
+	batch := make([]string, 0, mapBatchSize)
+	mapped := make([]mappedLine[K2, V], mapBatchSize)
+	for {
+		if err := t.ctx.Err(); err != nil {
 			return err
 		}
-		for _, v := range records {
-			p, err := t.mapper.Map(v)
-			if err != nil {
-				return err
-			}
-			err = out.Emit(p)
+		batch = batch[:0]
+		for len(batch) < mapBatchSize && in.Next() {
+			batch = append(batch, in.Value())
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		var wg sync.WaitGroup
+		for w := 0; w < t.workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < len(batch); i += t.workers {
+					mapped[i] = t.mapLine(batch[i])
+				}
+			}(w)
+		}
+		wg.Wait()
+		for i := range batch {
+			err := t.emit(mapped[i], out)
 			if err != nil {
 				return err
 			}
 		}
 	}
+}
+
+// mapLine split a line in records and map them, it may run on several goroutines
+func (t *textFileMapper[K1, K2, V]) mapLine(line string) mappedLine[K2, V] {
+	m := mappedLine[K2, V]{line: line}
+	records, err := t.mapper.LineToRecords(t.ctx, line)
+	if err != nil {
+		m.errs = append(m.errs, err)
+		return m
+	}
+	for _, v := range records {
+		p, err := t.mapper.Map(t.ctx, v)
+		if err != nil {
+			m.errs = append(m.errs, err)
+			continue
+		}
+		m.pairs = append(m.pairs, p)
+	}
+	return m
+}
+
+// emit the pairs of the next line of the input, skipping the records that failed
+func (t *textFileMapper[K1, K2, V]) emit(m mappedLine[K2, V], out Emitter[Pair[K2, V]]) error {
+	t.read++
+	for _, err := range m.errs {
+		err = t.bad.skip(t.source, t.read, m.line, err)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range m.pairs {
+		err := out.Emit(p)
+		if err != nil {
+			return err
+		}
+		t.emitted++
+	}
+	if t.lines > 0 {
+		t.ctx.Progress(float64(t.read) / float64(t.lines))
+	}
 	return nil
 }
 
 // MapTextFile is the entry point you must call
-// just provide filenames and split and map functions
-func MapTextFile[K1, K2 comparable, V any](fnIn, fnOut string,
+// just provide files, the job config and split and map functions.
+// The input is verified when it has a checksum, the output is partitioned
+// in files named outPrefix-N.txt, returns them with their checksums
+func MapTextFile[K1, K2 comparable, V any](fnIn FileRef, outPrefix string,
+	conf JobConfig,
 	mapper MapImplementation[K1, K2, V],
-) error {
-	fileMapper := newTextFileMapper(mapper)
-	in, err := NewTextFileIterator(fnIn)
+) ([]FileRef, error) {
+	return mapTextFile(localTaskContext("", conf), fnIn, outPrefix, ContextMapper(mapper), nil)
+}
+
+// mapTextFile run the mapper, when combiner is not nil the output
+// is combined before being written
+func mapTextFile[K1, K2 comparable, V any](ctx *TaskContext, fnIn FileRef, outPrefix string,
+	mapper MapContextImplementation[K1, K2, V],
+	combiner ReduceContextImplementation[K2, K2, V, V],
+) ([]FileRef, error) {
+	err := VerifyTextFile(fnIn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	fileMapper := newTextFileMapper(ctx, mapper)
+	if fnIn.Checksum != nil {
+		fileMapper.lines = fnIn.Checksum.Records
+	}
+	fileMapper.source = fnIn.Name
+	fileMapper.bad = newBadRecords(ctx, quarantineFile(outPrefix), CounterMapBadRecords)
+	defer fileMapper.bad.Close()
+	in, err := NewTextFileIterator(fnIn.Name)
+	if err != nil {
+		return nil, err
 	}
 	defer in.Close()
-	out, err := newTextFileEmitterForMapper[K2, V](fnOut)
+	impl := implementationOf(mapper)
+	partitionKey, _ := impl.(PartitionKeyProvider[K2])
+	parts, err := newTextFileEmitterForMapper(outPrefix, ctx.Config, outputCodecFor[K2, V](impl), partitionKey)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var out Emitter[Pair[K2, V]] = parts
+	if combiner != nil {
+		out = newCombiningEmitter(ctx, combiner, parts)
+	}
+	err = fileMapper.Map(in, out)
+	if err != nil {
+		// the pairs held by the combiner are dropped and the partial partitions removed
+		parts.abort()
+		return nil, err
+	}
+	err = out.Close()
+	if err != nil {
+		parts.abort()
+		return nil, err
 	}
-	defer out.Close()
-	return fileMapper.Map(in, out)
+	return parts.Files(), nil
 }
@@ -0,0 +1,160 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingMapper emit a pair for each word and fail on the word fail
+type failingMapper struct{}
+
+func (failingMapper) LineToRecords(_ *TaskContext, line string) ([]string, error) {
+	return strings.Fields(line), nil
+}
+
+func (failingMapper) Map(_ *TaskContext, word string) (Pair[string, int], error) {
+	if word == "fail" {
+		return Pair[string, int]{}, errors.New("can not map fail")
+	}
+	return Pair[string, int]{Key: word, Value: 1}, nil
+}
+
+type countingCombiner struct {
+	calls int
+}
+
+func (c *countingCombiner) Reduce(_ *TaskContext, key string, values []int) ([]Pair[string, int], error) {
+	c.calls++
+	return []Pair[string, int]{{Key: key, Value: len(values)}}, nil
+}
+
+func TestFailedMapRemovesPartitions(t *testing.T) {
+	dir := t.TempDir()
+	fnIn := filepath.Join(dir, "in.txt")
+	err := os.WriteFile(fnIn, []byte("a b c\nb c\nfail\nc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := DefaultJobConfig()
+	conf.Partitions = 2
+	combiner := &countingCombiner{}
+	_, err = mapTextFile(localTaskContext("m-0", conf), FileRef{Name: fnIn}, filepath.Join(dir, "m-out-0"), failingMapper{}, combiner)
+	if err == nil {
+		t.Fatal("expected the map to fail")
+	}
+	if combiner.calls != 0 {
+		t.Errorf("combiner called %d times on a failed map", combiner.calls)
+	}
+	parts, _ := filepath.Glob(filepath.Join(dir, "m-out-0*"))
+	if len(parts) != 0 {
+		t.Errorf("partial partitions left: %v", parts)
+	}
+}
+
+// failingCombiner fail on the key c
+type failingCombiner struct{}
+
+func (failingCombiner) Reduce(_ *TaskContext, key string, values []int) ([]Pair[string, int], error) {
+	if key == "c" {
+		return nil, errors.New("can not combine c")
+	}
+	return []Pair[string, int]{{Key: key, Value: len(values)}}, nil
+}
+
+func TestFailedCombinerRemovesPartitions(t *testing.T) {
+	dir := t.TempDir()
+	fnIn := filepath.Join(dir, "in.txt")
+	err := os.WriteFile(fnIn, []byte("a b c\nb c\nc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := DefaultJobConfig()
+	conf.Partitions = 2
+	_, err = mapTextFile(localTaskContext("m-0", conf), FileRef{Name: fnIn}, filepath.Join(dir, "m-out-0"), failingMapper{}, failingCombiner{})
+	if err == nil {
+		t.Fatal("expected the combiner to fail")
+	}
+	parts, _ := filepath.Glob(filepath.Join(dir, "m-out-0*"))
+	if len(parts) != 0 {
+		t.Errorf("partial partitions left: %v", parts)
+	}
+}
+
+// slowMapper take longer on the first lines of each batch so the workers finish out of order
+type slowMapper struct{}
+
+func (slowMapper) LineToRecords(_ *TaskContext, line string) ([]string, error) {
+	return []string{line}, nil
+}
+
+func (slowMapper) Map(_ *TaskContext, line string) (Pair[string, int], error) {
+	var i int
+	fmt.Sscan(line, &i)
+	if i%mapBatchSize < 4 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return Pair[string, int]{Key: line, Value: i}, nil
+}
+
+type collectingEmitter[V any] struct {
+	values []V
+}
+
+func (c *collectingEmitter[V]) Emit(v V) error {
+	c.values = append(c.values, v)
+	return nil
+}
+
+func (c *collectingEmitter[V]) Close() error {
+	return nil
+}
+
+type linesIterator struct {
+	lines []string
+	i     int
+}
+
+func (l *linesIterator) Next() bool {
+	l.i++
+	return l.i <= len(l.lines)
+}
+
+func (l *linesIterator) Value() string {
+	return l.lines[l.i-1]
+}
+
+func (l *linesIterator) Error() error {
+	return nil
+}
+
+func (l *linesIterator) Close() error {
+	return nil
+}
+
+func TestMapConcurrentlyKeepsOrder(t *testing.T) {
+	conf := DefaultJobConfig()
+	conf.MapWorkers = 4
+	var lines []string
+	for i := 0; i < 2*mapBatchSize+10; i++ {
+		lines = append(lines, strconv.Itoa(i))
+	}
+	out := &collectingEmitter[Pair[string, int]]{}
+	err := newTextFileMapper[string, string, int](localTaskContext("m-0", conf), slowMapper{}).Map(&linesIterator{lines: lines}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.values) != len(lines) {
+		t.Fatalf("%d pairs emitted, want %d", len(out.values), len(lines))
+	}
+	for i, p := range out.values {
+		if p.Value != i {
+			t.Fatalf("pair %d is %v, the pairs are not in the order of the input", i, p)
+		}
+	}
+}
@@ -1,56 +1,214 @@
 package mapreduce
 
+import (
+	"fmt"
+	"sort"
+
+	"mapreduce/internal/tracing"
+)
+
 type textFileReducer[K1, K2 comparable, V1, V2 any] struct {
-	reducer ReduceImplementation[K1, K2, V1, V2]
+	ctx         *TaskContext
+	reducer     ReduceContextImplementation[K1, K2, V1, V2]
+	codec       Codec[K1, V1]
+	convergence Convergence[K1, K2, V1, V2]
+	compare     KeyComparator[K1]
+	group       GroupComparator[K1]
+	delta       float64
+	// names of the inputs and the records skipped reading them
+	names []string
+	bad   *badRecords
+	// shuffle is the span of the task verifying and merging the inputs, ended
+	// by ReduceAll when they are read
+	shuffle *tracing.Span
 }
 
 func newTextFileReducer[K1, K2 comparable, V1, V2 any](
-	reducer ReduceImplementation[K1, K2, V1, V2],
+	ctx *TaskContext,
+	reducer ReduceContextImplementation[K1, K2, V1, V2],
 ) *textFileReducer[K1, K2, V1, V2] {
+	impl := implementationOf(reducer)
+	convergence, _ := impl.(Convergence[K1, K2, V1, V2])
+	compare, _ := impl.(KeyComparator[K1])
+	group, _ := impl.(GroupComparator[K1])
 	return &textFileReducer[K1, K2, V1, V2]{
-		reducer: reducer,
+		ctx:         ctx,
+		reducer:     reducer,
+		codec:       inputCodecFor[K1, V1](impl),
+		convergence: convergence,
+		compare:     compare,
+		group:       group,
 	}
 }
 
-func (t *textFileReducer[K1, K2, V1, V2]) Reduce(in Iterator[string], out Emitter[[]Pair[K2, V2]]) error {
-	listByKeys := map[K1][]V1{}
-	for in.Next() {
-		line := in.Value()
-		pair, err := t.reducer.LineToPair(line)
-		if err != nil {
+func (t *textFileReducer[K1, K2, V1, V2]) Reduce(in Iterator[Pair[string, string]], out Emitter[[]Pair[K2, V2]]) error {
+	return t.ReduceAll([]Iterator[Pair[string, string]]{in}, out)
+}
+
+// ReduceAll merge the pairs of all the inputs before reducing them,
+// keys are reduced in the order of their encoded form so the output is sorted,
+// unless the reducer implements KeyComparator or GroupComparator
+func (t *textFileReducer[K1, K2, V1, V2]) ReduceAll(ins []Iterator[Pair[string, string]], out Emitter[[]Pair[K2, V2]]) (err error) {
+	keys := map[string]K1{}
+	listByKeys := map[string][]V1{}
+	records, groups, emitted := 0, 0, 0
+	span := t.shuffle
+	defer func() {
+		t.ctx.IncrCounter(CounterReduceInputRecords, int64(records))
+		t.ctx.IncrCounter(CounterReduceInputGroups, int64(groups))
+		t.ctx.IncrCounter(CounterReduceOutputRecords, int64(emitted))
+		span.End(err)
+	}()
+	for i, in := range ins {
+		source := fmt.Sprintf("input %d", i)
+		if i < len(t.names) {
+			source = t.names[i]
+		}
+		file, _ := in.(*recordFileIterator)
+		if file != nil && t.bad != nil {
+			file.skip = func(line int, record string, err error) error {
+				records++
+				return t.bad.skip(source, line, record, err)
+			}
+		}
+		n := 0
+		for in.Next() {
+			records++
+			n++
+			record := in.Value()
+			pair, err := t.codec.Decode(record.Key, record.Value)
+			if err != nil {
+				line := n
+				if file != nil {
+					line = file.records
+				}
+				err = t.bad.skip(source, line, record.Key+","+record.Value, err)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			keys[record.Key] = pair.Key
+			lst := listByKeys[record.Key]
+			listByKeys[record.Key] = append(lst, pair.Value)
+		}
+		if err := in.Error(); err != nil {
 			return err
 		}
-		lst := listByKeys[pair.Key]
-		listByKeys[pair.Key] = append(lst, pair.Value)
 	}
-	for k, v := range listByKeys {
-		p, err := t.reducer.Reduce(k, v)
+	if err := t.bad.check(records); err != nil {
+		return err
+	}
+	span.SetAttributes("records", records, "keys", len(keys))
+	span.End(nil)
+	_, span = tracing.Start(t.ctx, "reduce")
+	encoded := make([]string, 0, len(keys))
+	for k := range keys {
+		encoded = append(encoded, k)
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		if t.compare != nil {
+			c := t.compare.Compare(keys[encoded[i]], keys[encoded[j]])
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return encoded[i] < encoded[j]
+	})
+	for start := 0; start < len(encoded); {
+		if err := t.ctx.Err(); err != nil {
+			return err
+		}
+		t.ctx.Progress(float64(start) / float64(len(encoded)))
+		key := keys[encoded[start]]
+		values := listByKeys[encoded[start]]
+		end := start + 1
+		for ; t.group != nil && end < len(encoded); end++ {
+			if t.group.GroupCompare(key, keys[encoded[end]]) != 0 {
+				break
+			}
+			values = append(values, listByKeys[encoded[end]]...)
+		}
+		start = end
+		p, err := t.reducer.Reduce(t.ctx, key, values)
 		if err != nil {
 			return err
 		}
+		if t.convergence != nil {
+			t.delta += t.convergence.Delta(key, values, p)
+		}
 		err = out.Emit(p)
 		if err != nil {
 			return err
 		}
+		groups++
+		emitted += len(p)
 	}
 	return nil
 }
 
 // ReduceTextFile is the entry point you must call
-// just provide filenames and split and map functions
-func ReduceTextFile[K1, K2 comparable, V1, V2 any](fnIn, fnOut string,
+// just provide files, the job config and the reduce implementation.
+// The inputs are the partitions of the reducer written by every mapper,
+// they are verified when they have a checksum. Returns the checksum of the output
+func ReduceTextFile[K1, K2 comparable, V1, V2 any](fnIn []FileRef, fnOut string,
+	conf JobConfig,
 	reducer ReduceImplementation[K1, K2, V1, V2],
-) error {
-	fileReducer := newTextFileReducer(reducer)
-	in, err := NewTextFileIterator(fnIn)
+) (Checksum, error) {
+	result, err := reduceTextFile(localTaskContext("", conf), fnIn, fnOut, ContextReducer(reducer))
 	if err != nil {
-		return err
+		return Checksum{}, err
+	}
+	return *result.Files[0].Checksum, nil
+}
+
+// reduceTextFile reduce the inputs to fnOut, the result has the delta
+// of the reducer when it implements Convergence
+func reduceTextFile[K1, K2 comparable, V1, V2 any](ctx *TaskContext, fnIn []FileRef, fnOut string,
+	reducer ReduceContextImplementation[K1, K2, V1, V2],
+) (TaskResult, error) {
+	conf := ctx.Config
+	// the shuffle of the task verify and read the partitions written for it by the mappers
+	_, shuffle := tracing.Start(ctx, "shuffle")
+	shuffle.SetAttributes("files", len(fnIn))
+	names := make([]string, len(fnIn))
+	for i, ref := range fnIn {
+		err := VerifyRecordFile(ref, conf.Format)
+		if err != nil {
+			shuffle.End(err)
+			return TaskResult{}, &CorruptFileError{Name: ref.Name, Err: err}
+		}
+		names[i] = ref.Name
 	}
-	defer in.Close()
-	out, err := newTextFileEmitterForReducer[K2, V2](fnOut)
+	fileReducer := newTextFileReducer(ctx, reducer)
+	fileReducer.names = names
+	fileReducer.shuffle = shuffle
+	fileReducer.bad = newBadRecords(ctx, quarantineFile(fnOut), CounterReduceBadRecords)
+	defer fileReducer.bad.Close()
+	ins, err := OpenRecordFileIterators(names, conf.Format)
 	if err != nil {
-		return err
+		shuffle.End(err)
+		return TaskResult{}, err
+	}
+	defer CloseIterators(ins, names)
+	out, err := newTextFileEmitterForReducer(fnOut, conf, outputCodecFor[K2, V2](implementationOf(reducer)))
+	if err != nil {
+		shuffle.End(err)
+		return TaskResult{}, err
+	}
+	err = fileReducer.ReduceAll(ins, out)
+	if err != nil {
+		out.abort()
+		return TaskResult{}, err
+	}
+	err = out.Close()
+	if err != nil {
+		return TaskResult{}, err
+	}
+	sum := out.Checksum()
+	result := TaskResult{Files: []FileRef{{Name: fnOut, Checksum: &sum}}}
+	if fileReducer.convergence != nil {
+		result.Delta = &fileReducer.delta
 	}
-	defer out.Close()
-	return fileReducer.Reduce(in, out)
+	return result, nil
 }
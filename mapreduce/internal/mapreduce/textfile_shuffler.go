@@ -1,32 +1,40 @@
 package mapreduce
 
+// ShuffleTextFiles read the records of the in files and send each one
+// to the part selected by the partitioner of the job, parts are written
+// with the format and intermediate compression of the job.
+// Mappers already partition their output, this is useful to repartition files
 func ShuffleTextFiles(
 	in []string,
 	prefix string,
 	parts int,
-	hash HashFunc[string, int],
-) ([]string, error) {
-	iters, err := OpenTextFileLineIterators(in)
+	conf JobConfig,
+) ([]FileRef, error) {
+	iters, err := OpenRecordFileIterators(in, conf.Format)
 	if err != nil {
 		return nil, err
 	}
-	emitters, names, err := OpenTextFileLineEmitters(prefix, parts)
+	defer func() {
+		_ = CloseIterators(iters, in)
+	}()
+	emitters, names, err := OpenRecordFileEmitters(prefix, parts, conf.Format, conf.Compression)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		_ = CloseTextFileLineIterators(iters, in)
-		_ = CloseTextFileLineEmitters(emitters, names)
-	}()
 	for _, iter := range iters {
 		for iter.Next() {
-			key := iter.Value()
-			i := hash(key)
-			err = emitters[i].Emit(key)
+			record := iter.Value()
+			i := conf.Partitioner.Partition(record.Key, parts)
+			err = emitters[i].Emit(record)
 			if err != nil {
+				_ = CloseEmitters(emitters, names)
 				return nil, err
 			}
 		}
+		if err := iter.Error(); err != nil {
+			_ = CloseEmitters(emitters, names)
+			return nil, err
+		}
 	}
-	return names, nil
+	return CloseEmittersWithChecksum(emitters, names)
 }
@@ -2,32 +2,97 @@ package mapreduce
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 )
 
-func SplitTextFile(fname string, prefix string, parts int) ([]string, error) {
+// SplitTextFile distribute the lines of fname into parts files,
+// returns the files with their checksums
+func SplitTextFile(fname string, prefix string, parts int) ([]FileRef, error) {
+	return SplitTextFiles([]string{fname}, prefix, parts)
+}
+
+// SplitTextFiles distribute the lines of all the fnames into parts files,
+// returns the files with their checksums
+func SplitTextFiles(fnames []string, prefix string, parts int) ([]FileRef, error) {
+	iters, err := OpenTextFileLineIterators(fnames)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseTextFileLineIterators(iters, fnames)
 	emitters, names, err := OpenTextFileLineEmitters(prefix, parts)
 	if err != nil {
 		return nil, err
 	}
-	iter, err := NewTextFileIterator(fname)
+	i := 0
+	for _, iter := range iters {
+		for iter.Next() {
+			err := emitters[i].Emit(iter.Value())
+			if err != nil {
+				slog.Error("error writing split", "file", names[i], "err", err)
+				_ = CloseTextFileLineEmitters(emitters, names)
+				return nil, err
+			}
+			i = (i + 1) % parts
+		}
+		if err := iter.Error(); err != nil {
+			_ = CloseTextFileLineEmitters(emitters, names)
+			return nil, err
+		}
+	}
+	return CloseEmittersWithChecksum(emitters, names)
+}
+
+// SplitRecordFiles distribute the records of files written with format, the outputs
+// of a previous job, into parts files of csv lines. The files are verified against
+// their checksums, returns the parts with their checksums
+func SplitRecordFiles(refs []FileRef, format OutputFormat, prefix string, parts int) ([]FileRef, error) {
+	emitters, names, err := OpenTextFileLineEmitters(prefix, parts)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		_ = iter.Close()
-		_ = CloseTextFileLineEmitters(emitters, names)
-	}()
 	i := 0
-	for iter.Next() {
-		err := emitters[i].Emit(iter.Value())
+	for _, ref := range refs {
+		err = VerifyRecordFile(ref, format)
+		if err == nil {
+			err = splitRecordFile(ref.Name, format, func(line string) error {
+				err := emitters[i].Emit(line)
+				i = (i + 1) % parts
+				return err
+			})
+		}
 		if err != nil {
-			log.Printf("error writing to %s: %v\n", names[i], err)
+			_ = CloseTextFileLineEmitters(emitters, names)
 			return nil, err
 		}
-		i = (i + 1) % parts
 	}
-	return names, nil
+	return CloseEmittersWithChecksum(emitters, names)
+}
+
+// splitRecordFile emit each record of fname as a csv line
+func splitRecordFile(fname string, format OutputFormat, emit func(line string) error) error {
+	iter, err := NewRecordFileIterator(fname, format)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	var sb strings.Builder
+	writer := csvFormat{}.NewWriter(&sb)
+	for iter.Next() {
+		record := iter.Value()
+		sb.Reset()
+		err := writer.Write(record.Key, record.Value)
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err == nil {
+			err = emit(strings.TrimSuffix(sb.String(), "\n"))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+	}
+	return iter.Error()
 }
 
 func OpenTextFileLineEmitters(
@@ -46,13 +111,56 @@ func OpenTextFileLineEmitters(
 	return
 }
 
+func OpenRecordFileEmitters(
+	prefix string,
+	parts int,
+	format OutputFormat,
+	compression Compression,
+) (emitters []Emitter[Pair[string, string]], names []string, err error) {
+	for i := 0; i < parts; i++ {
+		name := fmt.Sprintf("%s-%d.txt", prefix, i)
+		emitter, err := NewRecordFileEmitter(name, format, compression)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		emitters = append(emitters, emitter)
+	}
+	return
+}
+
 func CloseTextFileLineEmitters(emitters []Emitter[string], names []string) error {
+	return CloseEmitters(emitters, names)
+}
+
+// CloseEmitters close all the emitters, returns the first error found
+func CloseEmitters[V any](emitters []Emitter[V], names []string) (err error) {
 	for i := 0; i < len(emitters); i++ {
-		err := emitters[i].Close()
-		if err != nil {
-			log.Printf("error closing %s: %v\n", names[i], err)
-			return err
+		e := emitters[i].Close()
+		if e != nil {
+			slog.Warn("error closing file", "file", names[i], "err", e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// CloseEmittersWithChecksum close all the emitters and return references
+// to their files, with the checksum when the emitter keeps one
+func CloseEmittersWithChecksum[V any](emitters []Emitter[V], names []string) ([]FileRef, error) {
+	err := CloseEmitters(emitters, names)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]FileRef, len(emitters))
+	for i, emitter := range emitters {
+		refs[i].Name = names[i]
+		if c, ok := emitter.(ChecksumEmitter); ok {
+			sum := c.Checksum()
+			refs[i].Checksum = &sum
 		}
 	}
-	return nil
+	return refs, nil
 }
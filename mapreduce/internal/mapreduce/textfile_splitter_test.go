@@ -0,0 +1,60 @@
+package mapreduce
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRecords write the pairs to a record file and return it with its checksum
+func writeRecords(t *testing.T, fname string, format OutputFormat, comp Compression, pairs ...Pair[string, string]) FileRef {
+	t.Helper()
+	emitter, err := NewRecordFileEmitter(fname, format, comp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pairs {
+		if err := emitter.Emit(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refs, err := CloseEmittersWithChecksum([]Emitter[Pair[string, string]]{emitter}, []string{fname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs[0]
+}
+
+func TestSplitRecordFiles(t *testing.T) {
+	dir := t.TempDir()
+	format, _ := FormatByName(FormatJSONLines)
+	in := writeRecords(t, filepath.Join(dir, "r-out-0.txt"), format, gzipCompression{},
+		Pair[string, string]{Key: "a", Value: "1"},
+		Pair[string, string]{Key: "b,c", Value: "2"},
+		Pair[string, string]{Key: "d", Value: "3"})
+	parts, err := SplitRecordFiles([]FileRef{in}, format, filepath.Join(dir, "m"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, part := range parts {
+		if err := VerifyTextFile(part); err != nil {
+			t.Error(err)
+		}
+		lines = append(lines, strings.Split(strings.TrimSpace(readAll(t, part.Name)), "\n")...)
+	}
+	want := []string{"a,1", "d,3", `"b,c",2`}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("split %q, want %q", lines, want)
+	}
+}
+
+func TestSplitRecordFilesVerifiesChecksums(t *testing.T) {
+	dir := t.TempDir()
+	in := writeRecords(t, filepath.Join(dir, "r-out-0.txt"), DefaultFormat, noCompression{}, Pair[string, string]{Key: "a", Value: "1"})
+	in.Checksum.Records++
+	_, err := SplitRecordFiles([]FileRef{in}, DefaultFormat, filepath.Join(dir, "m"), 1)
+	if err == nil {
+		t.Fatal("expected a checksum mismatch")
+	}
+}
@@ -41,5 +41,5 @@ type RecordSplitter[T any] func(T) []T
 // RecordMapper take an input and extract a Pair
 type RecordMapper[I any, K comparable, V any] func(I) Pair[K, V]
 
-// HashFunc calculate a hash for a given key, is used by shuffler
+// HashFunc calculate a hash for a given key
 type HashFunc[I comparable, O comparable] func(key I) O
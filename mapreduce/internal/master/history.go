@@ -0,0 +1,356 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+)
+
+// maxHistory is the number of finished jobs kept in memory, every job is written
+// to the history directory
+const maxHistory = 20
+
+// jobRecord is the history of a job, from the submission of the
+// pipeline to its final message. A resumed pipeline is a new job
+type jobRecord struct {
+	ID string `json:"id"`
+	// TraceID of the spans of the job
+	TraceID   string    `json:"trace_id,omitempty"`
+	Input     string    `json:"input"`
+	Submitted time.Time `json:"submitted"`
+	Finished  time.Time `json:"finished"`
+	// Outcome is running, succeed or failed
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// From is the first stage of the pipeline run by the job, 0 unless it is resumed
+	From     int              `json:"from"`
+	Stages   []stageRecord    `json:"stages"`
+	Tasks    []attemptRecord  `json:"tasks"`
+	Counters map[string]int64 `json:"counters,omitempty"`
+}
+
+// stageRecord is an iteration of a stage of the pipeline
+type stageRecord struct {
+	Stage     int              `json:"stage"`
+	Job       string           `json:"job"`
+	Mode      string           `json:"mode"`
+	Iteration int              `json:"iteration"`
+	Started   time.Time        `json:"started"`
+	Finished  time.Time        `json:"finished"`
+	Outcome   string           `json:"outcome"`
+	Delta     *float64         `json:"delta,omitempty"`
+	Counters  map[string]int64 `json:"counters,omitempty"`
+}
+
+// attemptRecord is an attempt of a task sent to a worker
+type attemptRecord struct {
+	Task      string    `json:"task"`
+	Kind      string    `json:"kind"`
+	Stage     int       `json:"stage"`
+	Iteration int       `json:"iteration"`
+	Worker    string    `json:"worker"`
+	Attempt   int       `json:"attempt"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	// Outcome is succeed, failed or canceled
+	Outcome     string           `json:"outcome"`
+	Error       string           `json:"error,omitempty"`
+	InputBytes  int64            `json:"input_bytes"`
+	OutputBytes int64            `json:"output_bytes"`
+	Counters    map[string]int64 `json:"counters,omitempty"`
+}
+
+// newJobRecord of the n-th job of the master, a pipeline run from the stage from
+func newJobRecord(n int, p *pipeline, submitted time.Time, from int) *jobRecord {
+	return &jobRecord{
+		ID:        fmt.Sprintf("%s-%d", submitted.Format("20060102-150405"), n),
+		Input:     p.input,
+		Submitted: submitted,
+		Outcome:   "running",
+		From:      from,
+		Stages:    []stageRecord{},
+		Tasks:     []attemptRecord{},
+	}
+}
+
+// currentStage of the job, nil before the first stage runs
+func (j *jobRecord) currentStage() *stageRecord {
+	if j == nil || len(j.Stages) == 0 {
+		return nil
+	}
+	return &j.Stages[len(j.Stages)-1]
+}
+
+// recordStage start the record of an iteration of a stage
+func (m *master) recordStage(i int, conf mr.JobConfig, iteration int) {
+	if m.job == nil {
+		return
+	}
+	m.job.Stages = append(m.job.Stages, stageRecord{
+		Stage:     i,
+		Job:       conf.Job,
+		Mode:      conf.Mode,
+		Iteration: iteration,
+		Started:   time.Now(),
+		Outcome:   "running",
+	})
+}
+
+// finishStage end the record of the running stage
+func (m *master) finishStage(outcome string, delta *float64, counters map[string]int64) {
+	s := m.job.currentStage()
+	if s == nil || s.Outcome != "running" {
+		return
+	}
+	s.Finished = time.Now()
+	s.Outcome = outcome
+	s.Delta = delta
+	s.Counters = counters
+}
+
+// recordAttempt add the last attempt of a task to the job, the bytes are the
+// sizes of the files read and written
+func (m *master) recordAttempt(t *task, outcome string) {
+	s := m.job.currentStage()
+	if s == nil {
+		return
+	}
+	finished := t.finished
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	m.job.Tasks = append(m.job.Tasks, attemptRecord{
+		Task:        t.id,
+		Kind:        t.stage,
+		Stage:       s.Stage,
+		Iteration:   s.Iteration,
+		Worker:      t.worker.name(),
+		Attempt:     t.attempts,
+		Started:     t.started,
+		Finished:    finished,
+		Outcome:     outcome,
+		Error:       t.err,
+		InputBytes:  filesSize(t.in),
+		OutputBytes: filesSize(t.result.Files),
+		Counters:    t.result.Counters,
+	})
+}
+
+// filesSize is the sum of the sizes of the files, files that can not be read count 0
+func filesSize(refs []mr.FileRef) int64 {
+	var size int64
+	for _, ref := range refs {
+		info, err := os.Stat(ref.Name)
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// finishHistory end the record of the job and write it to the history
+// directory, as json and as a trace of chrome
+func (m *master) finishHistory(outcome, reason string, counters map[string]int64) {
+	m.mu.Lock()
+	job := m.job
+	m.job = nil
+	if job == nil {
+		m.mu.Unlock()
+		return
+	}
+	job.Finished = time.Now()
+	job.Outcome = outcome
+	job.Error = reason
+	job.Counters = counters
+	m.history = append(m.history, job)
+	if len(m.history) > maxHistory {
+		m.history = m.history[len(m.history)-maxHistory:]
+	}
+	m.mu.Unlock()
+	dir := m.historyDir()
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = writeJSON(filepath.Join(dir, job.ID+".json"), job)
+	}
+	if err == nil {
+		err = writeJSON(filepath.Join(dir, job.ID+".trace.json"), job.trace())
+	}
+	if err != nil {
+		m.log().Warn("can not write job history", "id", job.ID, "err", err)
+		return
+	}
+	m.log().Info("job history written", "id", job.ID, "dir", dir)
+}
+
+// historyDir where the jobs are written, history in the work directory
+func (m *master) historyDir() string {
+	return filepath.Join(m.workdir, "history")
+}
+
+// findHistory return the record of a finished job, from memory or from the history directory
+func (m *master) findHistory(id string) (*jobRecord, error) {
+	m.mu.Lock()
+	for _, job := range m.history {
+		if job.ID == id {
+			m.mu.Unlock()
+			return job, nil
+		}
+	}
+	m.mu.Unlock()
+	if id == "" || filepath.Base(id) != id {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(m.historyDir(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var job jobRecord
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, fmt.Errorf("history of %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+func writeJSON(fname string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, append(data, '\n'), 0644)
+}
+
+// traceEvent of the trace event format of chrome://tracing and Perfetto,
+// times are in microseconds since the submission of the job
+type traceEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat,omitempty"`
+	Ph    string         `json:"ph"`
+	Ts    int64          `json:"ts"`
+	Dur   int64          `json:"dur,omitempty"`
+	Pid   int            `json:"pid"`
+	Tid   int            `json:"tid"`
+	Cname string         `json:"cname,omitempty"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// trace of the job, the master is the process 0 with a thread for the stages
+// of the pipeline and one for their map and reduce phases, each worker is a
+// process with a thread for each task it runs at the same time
+func (j *jobRecord) trace() traceFile {
+	micros := func(t time.Time) int64 {
+		return t.Sub(j.Submitted).Microseconds()
+	}
+	span := func(name, cat string, pid, tid int, start, end time.Time, args map[string]any) traceEvent {
+		return traceEvent{Name: name, Cat: cat, Ph: "X", Ts: micros(start), Dur: max(end.Sub(start).Microseconds(), 1), Pid: pid, Tid: tid, Args: args}
+	}
+	meta := func(name string, pid, tid int, value string) traceEvent {
+		return traceEvent{Name: name, Ph: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": value}}
+	}
+	events := []traceEvent{
+		meta("process_name", 0, 0, "master"),
+		meta("thread_name", 0, 0, "stages"),
+		meta("thread_name", 0, 1, "phases"),
+	}
+	type phase struct {
+		kind       string
+		start, end time.Time
+	}
+	for _, s := range j.Stages {
+		end := s.Finished
+		if end.IsZero() {
+			end = j.Finished
+		}
+		name := fmt.Sprintf("stage %d %s", s.Stage, s.Job)
+		if s.Iteration > 0 {
+			name += fmt.Sprintf(" iteration %d", s.Iteration)
+		}
+		ev := span(name, "stage", 0, 0, s.Started, end, map[string]any{"outcome": s.Outcome, "counters": s.Counters})
+		if s.Outcome == "failed" {
+			ev.Cname = "terrible"
+		}
+		events = append(events, ev)
+		// the phases of the stage run from its first task to the end of its last one
+		var phases []*phase
+		for _, a := range j.Tasks {
+			if a.Stage != s.Stage || a.Iteration != s.Iteration {
+				continue
+			}
+			var p *phase
+			for _, candidate := range phases {
+				if candidate.kind == a.Kind {
+					p = candidate
+				}
+			}
+			if p == nil {
+				p = &phase{kind: a.Kind, start: a.Started, end: a.Finished}
+				phases = append(phases, p)
+			}
+			if a.Started.Before(p.start) {
+				p.start = a.Started
+			}
+			if a.Finished.After(p.end) {
+				p.end = a.Finished
+			}
+		}
+		for _, p := range phases {
+			events = append(events, span(p.kind, "phase", 0, 1, p.start, p.end, nil))
+		}
+	}
+	// a process for each worker, its tasks in the first free lane
+	pids := map[string]int{}
+	lanes := map[string][]time.Time{}
+	attempts := append([]attemptRecord(nil), j.Tasks...)
+	sort.SliceStable(attempts, func(a, b int) bool {
+		return attempts[a].Started.Before(attempts[b].Started)
+	})
+	for _, a := range attempts {
+		pid, ok := pids[a.Worker]
+		if !ok {
+			pid = len(pids) + 1
+			pids[a.Worker] = pid
+			events = append(events, meta("process_name", pid, 0, a.Worker))
+		}
+		lane := 0
+		for lane < len(lanes[a.Worker]) && lanes[a.Worker][lane].After(a.Started) {
+			lane++
+		}
+		if lane == len(lanes[a.Worker]) {
+			lanes[a.Worker] = append(lanes[a.Worker], time.Time{})
+			events = append(events, meta("thread_name", pid, lane, fmt.Sprintf("slot %d", lane)))
+		}
+		lanes[a.Worker][lane] = a.Finished
+		ev := span(a.Task, a.Kind, pid, lane, a.Started, a.Finished, map[string]any{
+			"stage":        a.Stage,
+			"iteration":    a.Iteration,
+			"attempt":      a.Attempt,
+			"outcome":      a.Outcome,
+			"input_bytes":  a.InputBytes,
+			"output_bytes": a.OutputBytes,
+		})
+		if a.Error != "" {
+			ev.Args["error"] = a.Error
+		}
+		if len(a.Counters) > 0 {
+			ev.Args["counters"] = a.Counters
+		}
+		switch a.Outcome {
+		case "failed":
+			ev.Cname = "terrible"
+		case "canceled":
+			ev.Cname = "grey"
+		}
+		events = append(events, ev)
+	}
+	return traceFile{TraceEvents: events, DisplayTimeUnit: "ms"}
+}
@@ -0,0 +1,144 @@
+package master
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+)
+
+// testJob has a stage with two maps overlapping on mapper 0, a failed one
+// on mapper 1 and a reduce after them
+func testJob(submitted time.Time) *jobRecord {
+	at := func(ms int) time.Time {
+		return submitted.Add(time.Duration(ms) * time.Millisecond)
+	}
+	job := newJobRecord(1, &pipeline{input: "in.txt"}, submitted, 0)
+	job.Stages = append(job.Stages, stageRecord{Stage: 0, Job: "wordcount", Mode: mr.ModeMapReduce, Started: at(1), Finished: at(50), Outcome: "succeed", Counters: map[string]int64{"map.input.lines": 3}})
+	job.Tasks = append(job.Tasks,
+		attemptRecord{Task: "m-0", Kind: "map", Worker: "mapper 0", Attempt: 1, Started: at(2), Finished: at(10), Outcome: "succeed", Counters: map[string]int64{"map.input.lines": 2}},
+		attemptRecord{Task: "m-1", Kind: "map", Worker: "mapper 0", Attempt: 1, Started: at(3), Finished: at(12), Outcome: "succeed"},
+		attemptRecord{Task: "m-2", Kind: "map", Worker: "mapper 1", Attempt: 1, Started: at(4), Finished: at(5), Outcome: "failed", Error: "boom"},
+		attemptRecord{Task: "m-2", Kind: "map", Worker: "mapper 0", Attempt: 2, Started: at(11), Finished: at(20), Outcome: "succeed"},
+		attemptRecord{Task: "r-0", Kind: "reduce", Worker: "reducer 0", Attempt: 1, Started: at(21), Finished: at(49), Outcome: "succeed"},
+	)
+	job.Finished = at(50)
+	job.Outcome = "succeed"
+	return job
+}
+
+func TestTrace(t *testing.T) {
+	trace := testJob(time.Now()).trace()
+	if trace.DisplayTimeUnit != "ms" {
+		t.Errorf("display time unit %q", trace.DisplayTimeUnit)
+	}
+	spans := map[string][]traceEvent{}
+	names := map[[2]int]string{}
+	for _, ev := range trace.TraceEvents {
+		switch ev.Ph {
+		case "X":
+			spans[ev.Name] = append(spans[ev.Name], ev)
+		case "M":
+			names[[2]int{ev.Pid, ev.Tid}] = names[[2]int{ev.Pid, ev.Tid}] + ev.Name + "=" + ev.Args["name"].(string) + " "
+		default:
+			t.Errorf("unexpected event %+v", ev)
+		}
+	}
+	stage := spans["stage 0 wordcount"]
+	if len(stage) != 1 || stage[0].Ts != 1000 || stage[0].Dur != 49000 || stage[0].Pid != 0 || stage[0].Tid != 0 {
+		t.Errorf("stage span %+v", stage)
+	}
+	// the phases run from the first task of their kind to the end of the last one
+	if p := spans["map"]; len(p) != 1 || p[0].Ts != 2000 || p[0].Dur != 18000 || p[0].Tid != 1 {
+		t.Errorf("map phase %+v", p)
+	}
+	if p := spans["reduce"]; len(p) != 1 || p[0].Ts != 21000 || p[0].Dur != 28000 {
+		t.Errorf("reduce phase %+v", p)
+	}
+	// workers are processes in the order of their first task, the overlapping
+	// tasks of a worker are in different lanes, a lane is reused once free
+	lanes := map[string][2]int{}
+	for _, name := range []string{"m-0", "m-1", "r-0"} {
+		if len(spans[name]) != 1 {
+			t.Fatalf("spans of %s: %+v", name, spans[name])
+		}
+		lanes[name] = [2]int{spans[name][0].Pid, spans[name][0].Tid}
+	}
+	m2 := spans["m-2"]
+	if len(m2) != 2 {
+		t.Fatalf("attempts of m-2: %+v", m2)
+	}
+	lanes["m-2 failed"] = [2]int{m2[0].Pid, m2[0].Tid}
+	lanes["m-2"] = [2]int{m2[1].Pid, m2[1].Tid}
+	want := map[string][2]int{"m-0": {1, 0}, "m-1": {1, 1}, "m-2 failed": {2, 0}, "m-2": {1, 0}, "r-0": {3, 0}}
+	if !reflect.DeepEqual(lanes, want) {
+		t.Errorf("lanes of the tasks %v, want %v", lanes, want)
+	}
+	if m2[0].Cname != "terrible" || m2[0].Args["error"] != "boom" || m2[1].Cname != "" {
+		t.Errorf("failed attempt %+v then %+v", m2[0], m2[1])
+	}
+	for lane, want := range map[[2]int]string{
+		{0, 0}: "process_name=master thread_name=stages ",
+		{1, 0}: "process_name=mapper 0 thread_name=slot 0 ",
+		{1, 1}: "thread_name=slot 1 ",
+		{3, 0}: "process_name=reducer 0 thread_name=slot 0 ",
+	} {
+		if names[lane] != want {
+			t.Errorf("names of %v %q, want %q", lane, names[lane], want)
+		}
+	}
+}
+
+// the record of a job is written as json and as a trace, it is read back once
+// it is no longer in memory
+func TestFinishHistory(t *testing.T) {
+	m := New("localhost:0", mr.DefaultJobConfig(), 3, t.TempDir(), nil).(*master)
+	submitted := time.Now()
+	m.job = testJob(submitted)
+	m.finishHistory("failed", "stage 0 failed", map[string]int64{"map.input.lines": 3})
+	if m.job != nil || len(m.history) != 1 {
+		t.Fatalf("job %v history %v", m.job, m.history)
+	}
+	job := m.history[0]
+	if job.Outcome != "failed" || job.Error != "stage 0 failed" || job.Counters["map.input.lines"] != 3 {
+		t.Errorf("finished job %+v", job)
+	}
+	var trace traceFile
+	data, err := os.ReadFile(filepath.Join(m.historyDir(), job.ID+".trace.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &trace)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.TraceEvents) != len(job.trace().TraceEvents) {
+		t.Errorf("trace written with %d events, want %d", len(trace.TraceEvents), len(job.trace().TraceEvents))
+	}
+	m.history = nil
+	read, err := m.findHistory(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.ID != job.ID || read.Input != "in.txt" || !read.Submitted.Equal(submitted) || len(read.Stages) != 1 || len(read.Tasks) != 5 {
+		t.Errorf("history read %+v", read)
+	}
+	if read.Tasks[2].Error != "boom" || read.Stages[0].Counters["map.input.lines"] != 3 {
+		t.Errorf("tasks %+v stages %+v", read.Tasks, read.Stages)
+	}
+	var served traceFile
+	if err := json.Unmarshal(get(t, m, "/api/history/"+job.ID+"/trace").Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served.TraceEvents) != len(trace.TraceEvents) {
+		t.Errorf("trace served with %d events, want %d", len(served.TraceEvents), len(trace.TraceEvents))
+	}
+	for _, id := range []string{"", "../" + job.ID, "unknown"} {
+		if _, err := m.findHistory(id); err == nil {
+			t.Errorf("history of %q found", id)
+		}
+	}
+}
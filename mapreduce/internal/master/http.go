@@ -0,0 +1,346 @@
+package master
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/metrics"
+)
+
+// maxFailures kept by the master for the dashboard
+const maxFailures = 20
+
+// failure is a failed attempt of a task
+type failure struct {
+	at      time.Time
+	stage   string
+	task    string
+	worker  string
+	attempt int
+	err     string
+}
+
+// addFailure record the last attempt of a task as failed
+func (m *master) addFailure(t *task) {
+	m.failures = append(m.failures, failure{
+		at:      t.finished,
+		stage:   t.stage,
+		task:    t.id,
+		worker:  t.worker.name(),
+		attempt: t.attempts,
+		err:     t.err,
+	})
+	if len(m.failures) > maxFailures {
+		m.failures = m.failures[len(m.failures)-maxFailures:]
+	}
+}
+
+// the documents of the json api, durations are in seconds
+
+type statusJSON struct {
+	Time     time.Time     `json:"time"`
+	Workers  []workerJSON  `json:"workers"`
+	Job      *jobJSON      `json:"job"`
+	Tasks    []taskJSON    `json:"tasks"`
+	Failures []failureJSON `json:"failures"`
+	History  []historyJSON `json:"history"`
+}
+
+type workerJSON struct {
+	Kind       string    `json:"kind"`
+	ID         int       `json:"id"`
+	Slots      int       `json:"slots"`
+	Jobs       []string  `json:"jobs"`
+	Lost       bool      `json:"lost"`
+	Registered time.Time `json:"registered"`
+	Running    int       `json:"running"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+}
+
+type jobJSON struct {
+	Input    string           `json:"input"`
+	Running  bool             `json:"running"`
+	Started  time.Time        `json:"started"`
+	Elapsed  float64          `json:"elapsed"`
+	Current  int              `json:"current"`
+	Stages   []stageJSON      `json:"stages"`
+	Counters map[string]int64 `json:"counters,omitempty"`
+}
+
+type stageJSON struct {
+	Job        string           `json:"job"`
+	Mode       string           `json:"mode"`
+	Status     string           `json:"status"`
+	Iteration  int              `json:"iteration"`
+	Iterations int              `json:"iterations"`
+	Delta      *float64         `json:"delta,omitempty"`
+	Elapsed    float64          `json:"elapsed"`
+	Progress   float64          `json:"progress"`
+	Error      string           `json:"error,omitempty"`
+	Counters   map[string]int64 `json:"counters,omitempty"`
+}
+
+type taskJSON struct {
+	ID       string           `json:"id"`
+	Stage    string           `json:"stage"`
+	Worker   string           `json:"worker"`
+	Status   string           `json:"status"`
+	Attempts int              `json:"attempts"`
+	Progress float64          `json:"progress"`
+	Started  time.Time        `json:"started"`
+	Finished *time.Time       `json:"finished,omitempty"`
+	Duration float64          `json:"duration"`
+	Error    string           `json:"error,omitempty"`
+	Counters map[string]int64 `json:"counters,omitempty"`
+}
+
+type failureJSON struct {
+	Time    time.Time `json:"time"`
+	Stage   string    `json:"stage"`
+	Task    string    `json:"task"`
+	Worker  string    `json:"worker"`
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+}
+
+// historyJSON summarize a finished job, its record is on /api/history/<id>
+type historyJSON struct {
+	ID        string    `json:"id"`
+	Input     string    `json:"input"`
+	Submitted time.Time `json:"submitted"`
+	Elapsed   float64   `json:"elapsed"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (s taskStatus) String() string {
+	switch s {
+	case taskFailed:
+		return "failed"
+	case taskSucceed:
+		return "succeed"
+	case taskWaiting:
+		return "waiting"
+	}
+	return "running"
+}
+
+// snapshot of the state of the master for the dashboard
+func (m *master) snapshot() statusJSON {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	s := statusJSON{Time: now, Workers: []workerJSON{}, Tasks: []taskJSON{}, Failures: []failureJSON{}, History: []historyJSON{}}
+	running := map[*worker]int{}
+	ids := make([]string, 0, len(m.tasks))
+	for id, t := range m.tasks {
+		ids = append(ids, id)
+		if t.status == taskRunning {
+			running[t.worker]++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	for _, workers := range [][]*worker{m.mappers, m.reducers} {
+		for _, w := range workers {
+			s.Workers = append(s.Workers, workerJSON{
+				Kind:       w.kind,
+				ID:         w.id,
+				Slots:      w.slots,
+				Jobs:       strings.Split(w.jobNames(), ","),
+				Lost:       w.lost,
+				Registered: w.registered,
+				Running:    running[w],
+				Succeeded:  w.succeeded,
+				Failed:     w.failed,
+			})
+		}
+	}
+	for _, id := range ids {
+		t := m.tasks[id]
+		tj := taskJSON{
+			ID:       t.id,
+			Stage:    t.stage,
+			Worker:   t.worker.name(),
+			Status:   t.status.String(),
+			Attempts: t.attempts,
+			Progress: t.progress,
+			Started:  t.started,
+			Duration: now.Sub(t.started).Seconds(),
+			Error:    t.err,
+			Counters: t.result.Counters,
+		}
+		if !t.finished.IsZero() {
+			finished := t.finished
+			tj.Finished = &finished
+			tj.Duration = t.finished.Sub(t.started).Seconds()
+		}
+		if t.status == taskSucceed {
+			tj.Progress = 1
+		}
+		s.Tasks = append(s.Tasks, tj)
+	}
+	for _, f := range m.failures {
+		s.Failures = append(s.Failures, failureJSON{
+			Time:    f.at,
+			Stage:   f.stage,
+			Task:    f.task,
+			Worker:  f.worker,
+			Attempt: f.attempt,
+			Error:   f.err,
+		})
+	}
+	// the last jobs first
+	for i := len(m.history) - 1; i >= 0; i-- {
+		job := m.history[i]
+		s.History = append(s.History, historyJSON{
+			ID:        job.ID,
+			Input:     job.Input,
+			Submitted: job.Submitted,
+			Elapsed:   job.Finished.Sub(job.Submitted).Seconds(),
+			Outcome:   job.Outcome,
+			Error:     job.Error,
+		})
+	}
+	if m.pipeline != nil {
+		s.Job = m.jobSnapshot(now, s.Tasks)
+	}
+	return s
+}
+
+// jobSnapshot describe the pipeline, the progress of the current stage is
+// the mean of the progress of its tasks
+func (m *master) jobSnapshot(now time.Time, tasks []taskJSON) *jobJSON {
+	p := m.pipeline
+	job := &jobJSON{
+		Input:    p.input,
+		Running:  m.client != nil,
+		Started:  m.processStart,
+		Current:  p.current,
+		Counters: p.counters(),
+	}
+	end := now
+	if !job.Running && !m.processEnd.IsZero() {
+		end = m.processEnd
+	}
+	job.Elapsed = end.Sub(m.processStart).Seconds()
+	for i, ps := range p.stages {
+		sj := stageJSON{
+			Job:        ps.conf.Job,
+			Mode:       ps.conf.Mode,
+			Status:     ps.status.String(),
+			Iteration:  ps.iteration,
+			Iterations: ps.conf.Iterations,
+			Delta:      ps.delta,
+			Elapsed:    ps.elapsed.Seconds(),
+			Error:      ps.err,
+			Counters:   ps.counters,
+		}
+		switch {
+		case ps.status == stageSucceed:
+			sj.Progress = 1
+		case ps.status == stageRunning && i == p.current:
+			sj.Elapsed += now.Sub(m.stageStart).Seconds()
+			sj.Counters = mr.AddCounters(mr.AddCounters(nil, ps.counters), m.counters)
+			for _, t := range tasks {
+				sj.Progress += t.Progress / float64(len(tasks))
+			}
+		}
+		job.Stages = append(job.Stages, sj)
+	}
+	return job
+}
+
+// ServeHTTP serve the dashboard on / and the json api on /api/status, or
+// one of its parts on /api/workers, /api/job, /api/tasks, /api/failures and
+// /api/history. The record of a finished job is on /api/history/<id> and
+// its trace on /api/history/<id>/trace. The metrics of the process are on /metrics
+func (m *master) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var doc any
+	s := m.snapshot()
+	switch r.URL.Path {
+	case "/":
+		m.serveDashboard(w, s)
+		return
+	case "/metrics":
+		metrics.Default.ServeHTTP(w, r)
+		return
+	case "/api/status":
+		doc = s
+	case "/api/workers":
+		doc = s.Workers
+	case "/api/job":
+		doc = s.Job
+	case "/api/tasks":
+		doc = s.Tasks
+	case "/api/failures":
+		doc = s.Failures
+	case "/api/history":
+		doc = s.History
+	default:
+		id, found := strings.CutPrefix(r.URL.Path, "/api/history/")
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		id, trace := strings.CutSuffix(id, "/trace")
+		job, err := m.findHistory(id)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		doc = job
+		if trace {
+			doc = job.trace()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		m.log().Warn("error writing response", "path", r.URL.Path, "err", err)
+	}
+}
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboard = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.0f%%", f*100)
+	},
+	"seconds": func(s float64) string {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+	},
+	"clock": func(t time.Time) string {
+		return t.Format("15:04:05.000")
+	},
+	"counters": func(c map[string]int64) string {
+		return mr.FormatCounters(c)
+	},
+}).Parse(dashboardHTML))
+
+func (m *master) serveDashboard(w http.ResponseWriter, s statusJSON) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboard.Execute(w, s)
+	if err != nil {
+		m.log().Warn("error writing dashboard", "err", err)
+	}
+}
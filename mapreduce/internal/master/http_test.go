@@ -0,0 +1,99 @@
+package master
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+)
+
+// newTestMaster with a mapper running a task and a lost reducer
+func newTestMaster() *master {
+	m := New("localhost:0", mr.DefaultJobConfig(), 3, "", nil).(*master)
+	mapper := newWorker(0, "mapper", nil, []string{"wordcount"}, 2)
+	reducer := newWorker(1, "reducer", nil, []string{"wordcount", "grep"}, 1)
+	reducer.lost = true
+	m.mappers = []*worker{mapper}
+	m.reducers = []*worker{reducer}
+	m.tasks["m-0"] = &task{id: "m-0", stage: "map", worker: mapper, status: taskRunning, attempts: 1, progress: 0.25, started: time.Now()}
+	return m
+}
+
+func get(t *testing.T, m *master, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+func TestAPIStatus(t *testing.T) {
+	rec := get(t, newTestMaster(), "/api/status")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q", ct)
+	}
+	var s statusJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Workers) != 2 {
+		t.Fatalf("workers %+v, want the mapper and the reducer", s.Workers)
+	}
+	mapper, reducer := s.Workers[0], s.Workers[1]
+	if mapper.Kind != "mapper" || mapper.Slots != 2 || mapper.Running != 1 || mapper.Lost {
+		t.Errorf("mapper %+v", mapper)
+	}
+	if reducer.Kind != "reducer" || !reducer.Lost || strings.Join(reducer.Jobs, ",") != "grep,wordcount" {
+		t.Errorf("reducer %+v", reducer)
+	}
+	if len(s.Tasks) != 1 || s.Tasks[0].ID != "m-0" || s.Tasks[0].Status != "running" || s.Tasks[0].Progress != 0.25 {
+		t.Errorf("tasks %+v", s.Tasks)
+	}
+	if s.Job != nil || len(s.Failures) != 0 || len(s.History) != 0 {
+		t.Errorf("job, failures or history without jobs: %+v", s)
+	}
+}
+
+func TestAPIParts(t *testing.T) {
+	m := newTestMaster()
+	var tasks []taskJSON
+	if err := json.Unmarshal(get(t, m, "/api/tasks").Body.Bytes(), &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Worker != "mapper 0" {
+		t.Errorf("tasks %+v", tasks)
+	}
+	if body := get(t, m, "/").Body.String(); !strings.Contains(body, "m-0") {
+		t.Errorf("dashboard without the running task:\n%s", body)
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d", rec.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tasksStarted.Inc("map")
+	body := get(t, newTestMaster(), "/metrics").Body.String()
+	for _, want := range []string{
+		"# TYPE mapreduce_master_tasks_started_total counter",
+		`mapreduce_master_tasks_started_total{stage="map"} `,
+		"# TYPE mapreduce_master_task_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics without %q:\n%s", want, body)
+		}
+	}
+}
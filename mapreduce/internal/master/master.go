@@ -0,0 +1,174 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	n "mapreduce/internal/network"
+	"mapreduce/internal/tracing"
+)
+
+type master struct {
+	address     string
+	mappers     []*worker
+	reducers    []*worker
+	jobMappers  []*worker
+	jobReducers []*worker
+	tasks       map[string]*task
+	// mapTasks are the maps of the reduce stage running
+	mapTasks map[string]*task
+	// mu guards the state of the master, its methods that do not lock it expect it held
+	mu           sync.Mutex
+	client       n.Connection
+	jobCtx       context.Context
+	pipeline     *pipeline
+	prefix       string
+	processStart time.Time
+	processEnd   time.Time
+	stageStart   time.Time
+	// counters of the tasks of the current run of a stage, added
+	// to the stage when the run succeeds
+	counters    map[string]int64
+	defaults    mr.JobConfig
+	conf        mr.JobConfig
+	maxAttempts int
+	workdir     string
+	// failures are the last failed attempts of the tasks, the oldest first
+	failures []failure
+	// job is the history of the job running, history the last finished ones
+	job     *jobRecord
+	history []*jobRecord
+	jobs    int
+	// spans of the job, of the iteration of the stage and of its phase running
+	jobSpan   *tracing.Span
+	stageSpan *tracing.Span
+	phaseSpan *tracing.Span
+	// exporter of the spans, nil when the jobs are not traced
+	exporter tracing.Exporter
+	// jobLogger has the attributes of the stage running, nil without job
+	jobLogger atomic.Pointer[slog.Logger]
+}
+
+// New create the handler of the master listening on address, defaults are the
+// options of the jobs, a task is tried maxAttempts times and the files of the
+// jobs are written in workdir. The spans of the jobs are sent to exporter,
+// nil to not export them
+func New(address string, defaults mr.JobConfig, maxAttempts int, workdir string, exporter tracing.Exporter) n.Handler {
+	return &master{
+		address:     address,
+		tasks:       make(map[string]*task),
+		defaults:    defaults,
+		maxAttempts: maxAttempts,
+		workdir:     workdir,
+		exporter:    exporter,
+	}
+}
+
+func (m *master) Process(ctx context.Context, msg string) (string, error) {
+	conn := ctx.Value("connection").(n.Connection)
+	logger := n.Logger(ctx)
+	msg = strings.TrimSpace(msg)
+	logger.Debug("processing command", "command", msg)
+	split := strings.Split(msg, " ")
+	if len(split) == 0 {
+		return "", errors.New("empty command not supported")
+	}
+	cmd := strings.ToLower(split[0])
+	args := split[1:]
+	switch cmd {
+	case "register":
+		return m.register(ctx, conn, args...)
+	case "status":
+		return m.status(), nil
+	case "process":
+		if len(args) == 0 {
+			return "invalid process command, expected input file", nil
+		}
+		p, err := m.newPipeline(args[0], [][]string{args[1:]})
+		if err != nil {
+			return fmt.Sprintf("can't process because %s", err), nil
+		}
+		return m.startPipeline(ctx, conn, p, 0), nil
+	case "pipeline":
+		if len(args) < 2 {
+			return "invalid pipeline command, expected input file and stages", nil
+		}
+		p, err := m.newPipeline(args[0], parseStages(args[1:]))
+		if err != nil {
+			return fmt.Sprintf("can't process because %s", err), nil
+		}
+		return m.startPipeline(ctx, conn, p, 0), nil
+	case "resume":
+		return m.resume(ctx, conn), nil
+	case "map", "reduce":
+		if len(args) < 3 {
+			return fmt.Sprintf("invalid %s command", cmd), nil
+		}
+		if args[0] == "progress" {
+			m.taskProgress(cmd, args[1], args[2])
+			return "", nil
+		}
+		m.processTaskResult(cmd, args[0], args[1], args[2:])
+		return "ok", nil
+	case "ok":
+		logger.Debug("ack received", "message", msg)
+		return "", nil
+	}
+	logger.Warn("unknown command", "command", msg)
+	return "unknown command", nil
+}
+
+func (m *master) registerClient(ctx context.Context, conn n.Connection) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil {
+		return false
+	}
+	m.client = conn
+	m.jobCtx = ctx
+	m.processStart = time.Now()
+	m.processEnd = time.Time{}
+	return true
+}
+
+func (m *master) removeClient() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = nil
+	m.jobCtx = nil
+	m.processEnd = time.Now()
+}
+
+// notifyClient send a message to the client that submitted the job
+func (m *master) notifyClient(msg string, args ...any) {
+	m.mu.Lock()
+	client := m.client
+	m.mu.Unlock()
+	if client == nil {
+		return
+	}
+	_, err := client.Write(msg, args...)
+	if err != nil {
+		m.log().Warn("error sending status to client", "err", err)
+	}
+}
+
+// log return the logger of the master, with the job and the stage running
+func (m *master) log() *slog.Logger {
+	if logger := m.jobLogger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default().With("server", "master")
+}
+
+// taskLog return the logger of the master for a task, with the worker and the attempt
+func (m *master) taskLog(t *task) *slog.Logger {
+	return m.log().With("task", t.id, "worker", t.worker.name(), "attempt", t.attempts)
+}
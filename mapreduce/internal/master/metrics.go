@@ -0,0 +1,13 @@
+package master
+
+import "mapreduce/internal/metrics"
+
+// metrics of the master, registered in metrics.Default and served on /metrics
+var (
+	tasksStarted   = metrics.NewCounter("mapreduce_master_tasks_started_total", "Attempts of tasks sent to the workers.", "stage")
+	tasksSucceeded = metrics.NewCounter("mapreduce_master_tasks_succeeded_total", "Attempts of tasks succeeded.", "stage")
+	tasksFailed    = metrics.NewCounter("mapreduce_master_tasks_failed_total", "Attempts of tasks failed, retried or not.", "stage")
+	backupTasks    = metrics.NewCounter("mapreduce_master_backup_tasks_total", "Backup attempts of slow tasks sent to the workers.", "stage")
+	taskDuration   = metrics.NewHistogram("mapreduce_master_task_duration_seconds", "Duration of the attempts of tasks, from dispatch to result.", metrics.DurationBuckets, "stage")
+	workersGauge   = metrics.NewGauge("mapreduce_master_workers", "Workers connected to the master.", "kind")
+)
@@ -0,0 +1,443 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	n "mapreduce/internal/network"
+)
+
+const (
+	stagePending stageStatus = iota
+	stageRunning
+	stageFailed
+	stageSucceed
+)
+
+type stageStatus int
+
+func (s stageStatus) String() string {
+	switch s {
+	case stageRunning:
+		return "running"
+	case stageFailed:
+		return "failed"
+	case stageSucceed:
+		return "succeed"
+	}
+	return "pending"
+}
+
+// pipelineStage is a map reduce job, its input are the outputs
+// of the previous stage or the input of the pipeline for the first one.
+// An iterative stage runs again over its own outputs until the delta
+// reported by the reducers converges or it reaches its iterations
+type pipelineStage struct {
+	conf      mr.JobConfig
+	status    stageStatus
+	outputs   []mr.FileRef
+	err       string
+	elapsed   time.Duration
+	iteration int
+	delta     *float64
+	// counters of every task of the stage, summed over its iterations
+	counters map[string]int64
+}
+
+// converged tell if the stage must not run another iteration
+func (ps *pipelineStage) converged() bool {
+	if ps.iteration >= ps.conf.Iterations {
+		return true
+	}
+	return ps.delta != nil && *ps.delta <= ps.conf.Epsilon
+}
+
+// stageInput are the files of a source of a stage, the source
+// is empty for untagged inputs. The outputs of a previous stage or
+// iteration have their checksums and the format they were written with,
+// the format is nil for the text files of the input of the pipeline
+type stageInput struct {
+	source string
+	files  []mr.FileRef
+	format mr.OutputFormat
+}
+
+// parseInputs read the input of a pipeline, a list of files separated by commas,
+// files of a job with several inputs are tagged with their source as source=file
+func parseInputs(s string) ([]stageInput, error) {
+	var inputs []stageInput
+	bySource := map[string]int{}
+	for _, part := range strings.Split(s, ",") {
+		source, file, tagged := strings.Cut(part, "=")
+		if !tagged {
+			source, file = "", part
+		}
+		if file == "" || (tagged && source == "") {
+			return nil, fmt.Errorf("invalid input %s", part)
+		}
+		i, ok := bySource[source]
+		if !ok {
+			i = len(inputs)
+			bySource[source] = i
+			inputs = append(inputs, stageInput{source: source})
+		}
+		inputs[i].files = append(inputs[i].files, mr.FileRef{Name: file})
+	}
+	return inputs, nil
+}
+
+// inputFiles of every source
+func inputFiles(inputs []stageInput) []mr.FileRef {
+	var files []mr.FileRef
+	for _, input := range inputs {
+		files = append(files, input.files...)
+	}
+	return files
+}
+
+type pipeline struct {
+	input   string
+	stages  []*pipelineStage
+	current int
+}
+
+// newPipeline create a pipeline from the options of each stage, all the
+// stages must have workers connected for their job
+func (m *master) newPipeline(input string, stages [][]string) (*pipeline, error) {
+	p := &pipeline{input: input}
+	_, err := parseInputs(input)
+	if err != nil {
+		return nil, err
+	}
+	for i, opts := range stages {
+		conf, err := m.defaults.With(opts)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		conf.Files, err = sideFiles(conf.Files)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		if conf.Broadcast.Name != "" {
+			conf.Broadcast, err = sideFile(conf.Broadcast)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: broadcast table: %w", i, err)
+			}
+		}
+		mappers, reducers := m.workersFor(conf.Job)
+		err = checkWorkers(conf, mappers, reducers)
+		if err != nil {
+			return nil, err
+		}
+		p.stages = append(p.stages, &pipelineStage{conf: conf})
+	}
+	return p, nil
+}
+
+// checkWorkers verify there are workers for the stages run by the mode of the job
+func checkWorkers(conf mr.JobConfig, mappers, reducers []*worker) error {
+	if conf.Mode != mr.ModeReduceOnly && len(mappers) == 0 {
+		return fmt.Errorf("no mappers connected for job %s", conf.Job)
+	}
+	if conf.Mode != mr.ModeMapOnly && len(reducers) == 0 {
+		return fmt.Errorf("no reducers connected for job %s", conf.Job)
+	}
+	return nil
+}
+
+// sideFiles add the checksum to the side files of a job, workers
+// verify them when they copy them at the start of a task
+func sideFiles(files map[string]mr.FileRef) (map[string]mr.FileRef, error) {
+	refs := make(map[string]mr.FileRef, len(files))
+	for name, ref := range files {
+		ref, err := sideFile(ref)
+		if err != nil {
+			return nil, fmt.Errorf("side file %s: %w", name, err)
+		}
+		refs[name] = ref
+	}
+	return refs, nil
+}
+
+// sideFile make the path of a file read by the workers absolute and add its checksum
+func sideFile(ref mr.FileRef) (mr.FileRef, error) {
+	fname, err := filepath.Abs(ref.Name)
+	if err != nil {
+		return ref, err
+	}
+	sum, err := mr.ChecksumTextFile(fname)
+	if err != nil {
+		return ref, err
+	}
+	return mr.FileRef{Name: fname, Checksum: &sum}, nil
+}
+
+// parseStages read the stages of a pipeline command,
+// each stage is a comma separated list of key=value options
+func parseStages(args []string) [][]string {
+	stages := make([][]string, len(args))
+	for i, arg := range args {
+		stages[i] = strings.Split(arg, ",")
+	}
+	return stages
+}
+
+// startPipeline run the pipeline from the given stage
+func (m *master) startPipeline(ctx context.Context, conn n.Connection, p *pipeline, from int) string {
+	if !m.registerClient(ctx, conn) {
+		return "server is busy try later"
+	}
+	m.mu.Lock()
+	m.pipeline = p
+	m.jobs++
+	m.job = newJobRecord(m.jobs, p, m.processStart, from)
+	m.traceJob(from)
+	m.mu.Unlock()
+	go m.runStage(from)
+	return "ok, processing"
+}
+
+// resume run again the pipeline from its first stage that did not succeed
+func (m *master) resume(ctx context.Context, conn n.Connection) string {
+	m.mu.Lock()
+	p := m.pipeline
+	from := -1
+	if p != nil {
+		for i, ps := range p.stages {
+			if ps.status != stageSucceed {
+				from = i
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+	if from < 0 {
+		return "nothing to resume"
+	}
+	return m.startPipeline(ctx, conn, p, from)
+}
+
+// runStage start the map reduce job of the stage i of the pipeline
+func (m *master) runStage(i int) {
+	m.mu.Lock()
+	p := m.pipeline
+	p.current = i
+	ps := p.stages[i]
+	ps.status = stageRunning
+	ps.err = ""
+	conf := ps.conf
+	inputs, _ := parseInputs(p.input)
+	switch {
+	case ps.iteration > 0:
+		// the outputs are only replaced when an iteration succeeds,
+		// a failed iteration is resumed from the last one
+		inputs = []stageInput{{files: ps.outputs, format: conf.Format}}
+	case i > 0:
+		prev := p.stages[i-1]
+		inputs = []stageInput{{files: prev.outputs, format: prev.conf.Format}}
+	}
+	if ps.iteration == 0 {
+		ps.counters = nil
+	}
+	m.mu.Unlock()
+	mappers, reducers := m.workersFor(conf.Job)
+	err := checkWorkers(conf, mappers, reducers)
+	if err != nil {
+		m.failJob("job failed, %s\n", err)
+		return
+	}
+	// a task for each slot of the workers, one partition per reducer slot,
+	// mappers write them and reducers pull them,
+	// without reduce stage each mapper writes a single file
+	mappers, reducers = slotsOf(mappers), slotsOf(reducers)
+	conf.Partitions = len(reducers)
+	if conf.Mode != mr.ModeMapReduce {
+		conf.Partitions = 1
+	}
+	m.mu.Lock()
+	m.conf = conf
+	m.jobMappers = mappers
+	m.jobReducers = reducers
+	m.prefix = m.stagePrefix(i, ps.iteration)
+	m.stageStart = time.Now()
+	m.counters = nil
+	m.recordStage(i, conf, ps.iteration)
+	m.traceStage(i, ps.iteration)
+	m.mu.Unlock()
+	m.jobLogger.Store(slog.Default().With("server", "master", "job", conf.Job, "stage", i, "iteration", ps.iteration))
+	m.log().Info("running stage", "mode", conf.Mode, "mappers", len(mappers), "reducers", len(reducers))
+	if conf.Mode == mr.ModeReduceOnly {
+		m.startReduceOnlyStage(inputFiles(inputs))
+		return
+	}
+	m.startMapStage(inputs)
+}
+
+// stagePrefix of the files of an iteration of a pipeline stage in the work directory,
+// the first iteration of the first stage keeps the names used by single jobs. Odd
+// iterations write with the prefix i1-, so an iteration never replaces the outputs
+// of the last one it reads
+func (m *master) stagePrefix(i, iteration int) string {
+	prefix := filepath.Clean(m.workdir) + string(filepath.Separator)
+	if i > 0 {
+		prefix += fmt.Sprintf("s%d-", i)
+	}
+	if iteration%2 == 1 {
+		prefix += "i1-"
+	}
+	return prefix
+}
+
+// removeIteration remove the splits, partitions and outputs written with prefix
+func removeIteration(prefix string) error {
+	var errs []error
+	for _, pattern := range []string{"m-*", "r-out-*"} {
+		files, err := filepath.Glob(prefix + pattern)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			errs = append(errs, os.Remove(f))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pipelineStageDone record the outputs of the current stage and run
+// its next iteration or the next stage
+func (m *master) pipelineStageDone(outputs []mr.FileRef, delta *float64) {
+	m.mu.Lock()
+	p := m.pipeline
+	current := p.current
+	ps := p.stages[current]
+	ps.outputs = outputs
+	ps.elapsed += time.Since(m.stageStart)
+	ps.iteration++
+	ps.delta = delta
+	ps.counters = mr.AddCounters(ps.counters, m.counters)
+	m.finishStage("succeed", delta, m.counters)
+	if delta != nil {
+		m.stageSpan.SetAttributes("delta", *delta)
+	}
+	m.endStageTrace(nil)
+	converged := ps.converged()
+	if converged {
+		ps.status = stageSucceed
+	}
+	next := current + 1
+	last := next == len(p.stages)
+	var counters map[string]int64
+	if last && converged {
+		counters = p.counters()
+		m.endTrace(nil)
+	}
+	m.mu.Unlock()
+	if ps.iteration > 1 {
+		// the files of the previous iteration were the input of this one
+		err := removeIteration(m.stagePrefix(current, ps.iteration))
+		if err != nil {
+			m.log().Warn("can not remove the files of the previous iteration", "err", err)
+		}
+	}
+	if ps.conf.Iterations > 1 {
+		m.notifyClient("stage %d %s iteration %d finished, delta %s\n", current, ps.conf.Job, ps.iteration, formatDelta(delta))
+	}
+	if !converged {
+		go m.runStage(current)
+		return
+	}
+	if last {
+		m.log().Info("job finished", "elapsed", time.Since(m.processStart))
+		m.finishHistory("succeed", "", counters)
+		m.notifyClient("output %s\n", mr.FormatFileRefs(ps.outputs))
+		if len(counters) > 0 {
+			m.notifyClient("counters %s\n", mr.FormatCounters(counters))
+		}
+		m.finishJob("%s finished elapsed time %s\n", lastStage(ps.conf), time.Since(m.processStart))
+		return
+	}
+	m.notifyClient("stage %d %s finished, elapsed time %s\n", next-1, ps.conf.Job, ps.elapsed)
+	go m.runStage(next)
+}
+
+// counters of the job, the sum of the counters of its stages
+func (p *pipeline) counters() map[string]int64 {
+	var counters map[string]int64
+	for _, ps := range p.stages {
+		counters = mr.AddCounters(counters, ps.counters)
+	}
+	return counters
+}
+
+// failJob mark the current stage as failed, the pipeline can be resumed
+func (m *master) failJob(msg string, args ...any) {
+	reason := fmt.Sprintf(msg, args...)
+	m.log().Error("job failed", "reason", strings.TrimSpace(reason))
+	m.cancelTasks()
+	m.mu.Lock()
+	var counters map[string]int64
+	if m.pipeline != nil {
+		ps := m.pipeline.stages[m.pipeline.current]
+		ps.status = stageFailed
+		ps.err = strings.TrimSpace(reason)
+		m.finishStage("failed", nil, m.counters)
+		counters = m.pipeline.counters()
+	}
+	m.endTrace(errors.New(strings.TrimSpace(reason)))
+	m.mu.Unlock()
+	m.finishHistory("failed", strings.TrimSpace(reason), counters)
+	m.finishJob("%s", reason)
+}
+
+// lastStage run by the mode of a job
+func lastStage(conf mr.JobConfig) string {
+	if conf.Mode == mr.ModeMapOnly {
+		return "map"
+	}
+	return "reduce"
+}
+
+// formatDelta of the reducers, they may not report one
+func formatDelta(delta *float64) string {
+	if delta == nil {
+		return "unknown"
+	}
+	return strconv.FormatFloat(*delta, 'g', 6, 64)
+}
+
+// pipelineStatus describe each stage
+func (m *master) pipelineStatus() string {
+	if m.pipeline == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for i, ps := range m.pipeline.stages {
+		fmt.Fprintf(&sb, "stage %d %s %s", i, ps.conf.Job, ps.status)
+		if ps.conf.Iterations > 1 {
+			fmt.Fprintf(&sb, " iteration %d/%d delta %s", ps.iteration, ps.conf.Iterations, formatDelta(ps.delta))
+		}
+		if ps.status == stageSucceed {
+			fmt.Fprintf(&sb, " elapsed time %s", ps.elapsed)
+		}
+		if ps.err != "" {
+			fmt.Fprintf(&sb, " %s", ps.err)
+		}
+		counters := ps.counters
+		if ps.status == stageRunning {
+			counters = mr.AddCounters(mr.AddCounters(nil, counters), m.counters)
+		}
+		if len(counters) > 0 {
+			fmt.Fprintf(&sb, " counters %s", mr.FormatCounters(counters))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
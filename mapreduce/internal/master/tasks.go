@@ -0,0 +1,673 @@
+package master
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/tracing"
+)
+
+const (
+	taskRunning taskStatus = iota
+	taskFailed
+	taskSucceed
+	taskWaiting
+)
+
+type taskStatus int
+
+// backupSuffix of the id of the backup attempt of a task, its files have it too
+const backupSuffix = "-backup"
+
+type task struct {
+	id       string
+	stage    string
+	worker   *worker
+	source   string
+	in       []mr.FileRef
+	out      string
+	result   mr.TaskResult
+	status   taskStatus
+	attempts int
+	progress float64
+	// started and finished are the times of the last attempt
+	started  time.Time
+	finished time.Time
+	err      string
+	// span of the last attempt, the parent of the span of the worker
+	span *tracing.Span
+	// waiting is the map a reduce waits for, run again for a corrupt partition
+	waiting *task
+	// backup is the worker of the backup attempt of the task while it runs,
+	// a task gets a single backup
+	backup   *worker
+	backedUp bool
+}
+
+// startMapStage split each input in a part for each mapper slot, the tasks of
+// tagged inputs map the files of their source
+func (m *master) startMapStage(inputs []stageInput) {
+	m.mu.Lock()
+	m.mapTasks = nil
+	m.mu.Unlock()
+	parts := len(m.jobMappers)
+	m.log().Info("running map stage", "parts", parts)
+	m.tracePhase("map")
+	var tasks []*task
+	for _, input := range inputs {
+		prefix := m.prefix + "m"
+		if input.source != "" {
+			prefix += "-" + input.source
+		}
+		var files []mr.FileRef
+		var err error
+		if input.format != nil {
+			files, err = mr.SplitRecordFiles(input.files, input.format, prefix, parts)
+		} else {
+			files, err = mr.SplitTextFiles(fileNames(input.files), prefix, parts)
+		}
+		if err != nil {
+			m.failJob("job failed, can not split %s: %s\n", mr.FormatFileRefs(input.files), err)
+			return
+		}
+		for i, mapper := range m.jobMappers {
+			tasks = append(tasks, &task{
+				id:     fmt.Sprintf("m-%d", len(tasks)),
+				stage:  "map",
+				worker: mapper,
+				source: input.source,
+				in:     []mr.FileRef{files[i]},
+				out:    fmt.Sprintf("%sm-out-%d", m.prefix, len(tasks)),
+			})
+		}
+	}
+	m.runTasks(tasks)
+	m.log().Debug("mappers notified")
+}
+
+// startReduceStage send to each reducer the list of partitions written for it
+// by the mappers, reducers read and merge them
+func (m *master) startReduceStage() {
+	m.log().Info("running reduce stage", "partitions", len(m.jobReducers))
+	m.tracePhase("reduce")
+	files := m.partitionsByReducer()
+	m.mu.Lock()
+	// the maps are kept to run them again when a reducer finds a corrupt partition
+	m.mapTasks = m.tasks
+	m.mu.Unlock()
+	tasks := make([]*task, len(m.jobReducers))
+	for i, reducer := range m.jobReducers {
+		tasks[i] = &task{
+			id:     fmt.Sprintf("r-%d", i),
+			stage:  "reduce",
+			worker: reducer,
+			in:     files[i],
+			out:    fmt.Sprintf("%sr-out-%d.txt", m.prefix, i),
+		}
+	}
+	m.runTasks(tasks)
+	m.log().Debug("reducers notified")
+}
+
+// startReduceOnlyStage send each input file to a reducer, the files are
+// partitions of pairs so there is a reduce task for each one
+func (m *master) startReduceOnlyStage(in []mr.FileRef) {
+	m.log().Info("running reduce only stage", "partitions", len(in))
+	m.tracePhase("reduce")
+	tasks := make([]*task, len(in))
+	for i, ref := range in {
+		tasks[i] = &task{
+			id:     fmt.Sprintf("r-%d", i),
+			stage:  "reduce",
+			worker: m.jobReducers[i%len(m.jobReducers)],
+			in:     []mr.FileRef{ref},
+			out:    fmt.Sprintf("%sr-out-%d.txt", m.prefix, i),
+		}
+	}
+	m.runTasks(tasks)
+	m.log().Debug("reducers notified")
+}
+
+// runTasks replace the tasks of the previous stage and send them to their workers,
+// the job fails when its client disconnected
+func (m *master) runTasks(tasks []*task) {
+	m.mu.Lock()
+	m.tasks = make(map[string]*task, len(tasks))
+	for _, t := range tasks {
+		m.tasks[t.id] = t
+	}
+	ctx := m.jobCtx
+	m.mu.Unlock()
+	for _, t := range tasks {
+		if ctx == nil {
+			// the job already finished
+			return
+		}
+		if ctx.Err() != nil {
+			m.failJob("job failed, the client disconnected\n")
+			return
+		}
+		m.dispatch(t)
+	}
+}
+
+// dispatch send the task to its worker, a failure to send counts as a failed attempt
+func (m *master) dispatch(t *task) {
+	m.mu.Lock()
+	if t.worker.lost {
+		t.worker = m.replacement(t)
+	}
+	t.attempts++
+	t.status = taskRunning
+	t.progress = 0
+	t.started = time.Now()
+	t.finished = time.Time{}
+	t.err = ""
+	m.traceTask(t)
+	logger := m.taskLog(t)
+	m.mu.Unlock()
+	tasksStarted.Inc(t.stage)
+	conf := m.conf
+	conf.Source = t.source
+	conf.Trace = t.span.Context()
+	logger.Info("sending task")
+	_, err := t.worker.conn.Write("%s %s %s %s %s\n", t.stage, t.id, mr.FormatFileRefs(t.in), t.out, conf)
+	if err != nil {
+		logger.Warn("failed to send task", "err", err)
+		m.processTaskResult(t.stage, "error", t.id, []string{err.Error()})
+		return
+	}
+	if conf.BackupAfter > 0 {
+		time.AfterFunc(conf.BackupAfter, m.speculate)
+	}
+}
+
+// speculate send a backup attempt of the tasks running for longer than BackupAfter
+// to a worker of their stage with a free slot, the first attempt done wins. It runs
+// when a task has been running for BackupAfter and when a task is done
+func (m *master) speculate() {
+	m.mu.Lock()
+	after := m.conf.BackupAfter
+	if after <= 0 || m.jobCtx == nil {
+		m.mu.Unlock()
+		return
+	}
+	busy := map[*worker]int{}
+	var slow []*task
+	for _, t := range m.activeTasks() {
+		if t.status != taskRunning {
+			continue
+		}
+		busy[t.worker]++
+		if t.backup != nil {
+			busy[t.backup]++
+		}
+		if !t.backedUp && time.Since(t.started) >= after {
+			slow = append(slow, t)
+		}
+	}
+	sort.Slice(slow, func(i, j int) bool { return slow[i].started.Before(slow[j].started) })
+	var backups []*task
+	for _, t := range slow {
+		if w := m.idleWorker(t, busy); w != nil {
+			busy[w]++
+			t.backup, t.backedUp = w, true
+			backups = append(backups, t)
+		}
+	}
+	m.mu.Unlock()
+	for _, t := range backups {
+		m.dispatchBackup(t)
+	}
+}
+
+// idleWorker of the stage of t with a free slot, other than the one running t
+func (m *master) idleWorker(t *task, busy map[*worker]int) *worker {
+	workers := m.jobMappers
+	if t.stage == "reduce" {
+		workers = m.jobReducers
+	}
+	for _, w := range workers {
+		if w != t.worker && !w.lost && busy[w] < w.slots {
+			return w
+		}
+	}
+	return nil
+}
+
+// dispatchBackup send the backup attempt of t to its worker, it writes its own files
+func (m *master) dispatchBackup(t *task) {
+	m.mu.Lock()
+	w := t.backup
+	in := mr.FormatFileRefs(t.in)
+	conf := m.conf
+	conf.Source = t.source
+	conf.Trace = t.span.Context()
+	logger := m.taskLog(t).With("backup", w.name())
+	m.mu.Unlock()
+	backupTasks.Inc(t.stage)
+	logger.Info("sending backup task")
+	_, err := w.conn.Write("%s %s %s %s %s\n", t.stage, t.id+backupSuffix, in, backupOut(t.out), conf)
+	if err != nil {
+		logger.Warn("failed to send backup task", "err", err)
+		m.processTaskResult(t.stage, "error", t.id+backupSuffix, []string{err.Error()})
+	}
+}
+
+// backupOut return the output of the backup attempt of a task writing out
+func backupOut(out string) string {
+	if base, ok := strings.CutSuffix(out, ".txt"); ok {
+		return base + backupSuffix + ".txt"
+	}
+	return out + backupSuffix
+}
+
+// ownsFile tell if the file is an output of an attempt of t
+func ownsFile(t *task, name string) bool {
+	base := strings.TrimSuffix(t.out, ".txt")
+	return name == t.out || name == backupOut(t.out) || strings.HasPrefix(name, base+"-")
+}
+
+// replacement of the lost worker of a task, another worker of the job for
+// the stage
+func (m *master) replacement(t *task) *worker {
+	workers := m.jobMappers
+	if t.stage == "reduce" {
+		workers = m.jobReducers
+	}
+	for i := range workers {
+		w := workers[(t.attempts+i)%len(workers)]
+		if !w.lost {
+			return w
+		}
+	}
+	return t.worker
+}
+
+// processTaskResult handle the result reported for a task, failed tasks are sent
+// again until maxAttempts. A reduce that reports a corrupt partition waits for
+// the map that wrote it to run again
+func (m *master) processTaskResult(stage, status, taskId string, detail []string) {
+	taskId, backup := strings.CutSuffix(taskId, backupSuffix)
+	var out mr.TaskResult
+	var err error
+	switch status {
+	case "done":
+		out, err = mr.ParseTaskResult(detail)
+		if err == nil {
+			err = m.verifyOutputs(stage, out.Files)
+		}
+	case "error":
+		err = errors.New(strings.Join(detail, " "))
+	case "corrupt":
+		err = fmt.Errorf("corrupt partition %s", strings.Join(detail, " "))
+	default:
+		err = fmt.Errorf("unknown status %s", status)
+	}
+	m.mu.Lock()
+	t, ok := m.tasks[taskId]
+	rerun := false
+	if !ok && stage == "map" {
+		// a map run again during the reduce stage
+		t, ok = m.mapTasks[taskId]
+		rerun = ok
+	}
+	if !ok || t.stage != stage || t.status != taskRunning || (backup && t.backup == nil) {
+		// the files of an attempt done after the one that won are removed
+		var lost []string
+		if ok && t.status == taskSucceed {
+			for _, f := range out.Files {
+				if ownsFile(t, f.Name) && !slices.ContainsFunc(t.result.Files, func(r mr.FileRef) bool { return r.Name == f.Name }) {
+					lost = append(lost, f.Name)
+				}
+			}
+		}
+		m.mu.Unlock()
+		m.log().Debug("ignoring result", "stage", stage, "task", taskId, "backup", backup, "status", status)
+		for _, fname := range lost {
+			os.Remove(fname)
+		}
+		return
+	}
+	if backup && err != nil {
+		// the task goes on with its first attempt
+		m.taskLog(t).Warn("backup task failed", "backup", t.backup.name(), "err", err)
+		t.backup = nil
+		m.mu.Unlock()
+		return
+	}
+	t.finished = time.Now()
+	t.span.End(err)
+	taskDuration.Observe(t.finished.Sub(t.started).Seconds(), stage)
+	if status == "corrupt" && len(detail) > 0 {
+		if producer := m.producer(detail[0]); producer != nil {
+			m.taskLog(t).Warn("corrupt partition", "map", producer.id, "err", err)
+			tasksFailed.Inc(stage)
+			t.err = err.Error()
+			m.recordAttempt(t, "failed")
+			m.addFailure(t)
+			next, failed := m.waitFor(t, producer)
+			m.mu.Unlock()
+			if failed {
+				m.failJob("job failed, map task %s: %s\n", producer.id, err)
+				return
+			}
+			for _, t := range next {
+				m.dispatch(t)
+			}
+			return
+		}
+	}
+	if err == nil {
+		// the other attempt of a task with a backup is canceled
+		loser, loserId := t.backup, t.id+backupSuffix
+		if backup {
+			loser, loserId = t.worker, t.id
+			t.worker = t.backup
+		}
+		t.backup = nil
+		if loser != nil {
+			defer m.cancelAttempt(loser, loserId)
+		}
+		m.taskLog(t).Info("task done", "duration", t.finished.Sub(t.started), "backup", backup)
+		tasksSucceeded.Inc(stage)
+		t.status = taskSucceed
+		t.result = out
+		m.recordAttempt(t, "succeed")
+		t.worker.succeeded++
+		if rerun {
+			// its counters were added by the first run
+			waiting := m.mapDone(t)
+			m.mu.Unlock()
+			for _, t := range waiting {
+				m.dispatch(t)
+			}
+			return
+		}
+		m.counters = mr.AddCounters(m.counters, out.Counters)
+		finished := m.stageFinished()
+		m.mu.Unlock()
+		if finished {
+			m.stageDone(stage)
+			return
+		}
+		m.speculate()
+		return
+	}
+	m.taskLog(t).Warn("task failed", "err", err)
+	tasksFailed.Inc(stage)
+	t.err = err.Error()
+	t.worker.failed++
+	m.recordAttempt(t, "failed")
+	m.addFailure(t)
+	retry := t.attempts < m.maxAttempts
+	if !retry {
+		t.status = taskFailed
+	}
+	m.mu.Unlock()
+	if retry {
+		m.dispatch(t)
+		return
+	}
+	m.failJob("job failed, %s task %s: %s\n", stage, taskId, err)
+}
+
+// producer return the map task of the reduce stage that wrote the partition fname, nil
+// when the partition is not the output of a map of the stage
+func (m *master) producer(fname string) *task {
+	for _, t := range m.mapTasks {
+		for _, f := range t.result.Files {
+			if f.Name == fname {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// waitFor make the reduce task t wait for the map that wrote its corrupt partition,
+// it returns the tasks to send: the map, or t when it read the partitions of a
+// previous run of the map. failed is true when the map has no attempt left
+func (m *master) waitFor(t, producer *task) (next []*task, failed bool) {
+	t.status = taskWaiting
+	t.waiting = producer
+	switch {
+	case producer.status == taskRunning:
+		// the map already runs again for another reducer
+		return nil, false
+	case updateInputs(t, producer.result.Files):
+		t.waiting = nil
+		return []*task{t}, false
+	case producer.attempts >= m.maxAttempts:
+		producer.status = taskFailed
+		return nil, true
+	}
+	return []*task{producer}, false
+}
+
+// mapDone return the reduce tasks waiting for the map t, with the new partitions
+func (m *master) mapDone(t *task) []*task {
+	var waiting []*task
+	for _, r := range m.tasks {
+		if r.waiting == t {
+			updateInputs(r, t.result.Files)
+			r.waiting = nil
+			waiting = append(waiting, r)
+		}
+	}
+	return waiting
+}
+
+// updateInputs replace the inputs of t written again in files, it returns true
+// when one of them changed
+func updateInputs(t *task, files []mr.FileRef) bool {
+	changed := false
+	for i, in := range t.in {
+		for _, f := range files {
+			if f.Name == in.Name && (in.Checksum == nil || f.Checksum == nil || *f.Checksum != *in.Checksum) {
+				t.in[i] = f
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// activeTasks return the tasks of the stage and the maps run again during the reduce stage
+func (m *master) activeTasks() []*task {
+	tasks := make([]*task, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		tasks = append(tasks, t)
+	}
+	for _, t := range m.mapTasks {
+		if t.status == taskRunning {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+// taskProgress record the progress reported by the worker of a running task
+func (m *master) taskProgress(stage, taskId, progress string) {
+	done, err := strconv.ParseFloat(progress, 64)
+	if err != nil {
+		m.log().Warn("invalid progress", "task", taskId, "progress", progress)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tasks[taskId]
+	if ok && t.stage == stage && t.status == taskRunning {
+		t.progress = done
+	}
+}
+
+// cancelTasks ask the workers to stop the running tasks of a failed job
+func (m *master) cancelTasks() {
+	m.mu.Lock()
+	var running []*task
+	for _, t := range m.activeTasks() {
+		if t.status == taskRunning {
+			running = append(running, t)
+			m.recordAttempt(t, "canceled")
+			t.span.End(errCanceled)
+		}
+	}
+	m.mu.Unlock()
+	for _, t := range running {
+		m.cancelAttempt(t.worker, t.id)
+		if backup := t.backup; backup != nil {
+			m.cancelAttempt(backup, t.id+backupSuffix)
+		}
+	}
+}
+
+// cancelAttempt ask the worker to stop the attempt of a task
+func (m *master) cancelAttempt(w *worker, taskId string) {
+	_, err := w.conn.Write("cancel %s\n", taskId)
+	if err != nil {
+		m.log().Warn("failed to cancel task", "task", taskId, "worker", w.name(), "err", err)
+	}
+}
+
+// tasksStatus describe the tasks of the current stage
+func (m *master) tasksStatus() string {
+	ids := make([]string, 0, len(m.tasks))
+	for id := range m.tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var sb strings.Builder
+	for _, id := range ids {
+		t := m.tasks[id]
+		fmt.Fprintf(&sb, "task %s %s %d", t.id, t.worker.kind, t.worker.id)
+		switch t.status {
+		case taskRunning:
+			fmt.Fprintf(&sb, " running %.0f%%", t.progress*100)
+			if t.backup != nil {
+				fmt.Fprintf(&sb, " backup %s %d", t.backup.kind, t.backup.id)
+			}
+		case taskFailed:
+			sb.WriteString(" failed")
+		case taskSucceed:
+			sb.WriteString(" succeed")
+		case taskWaiting:
+			fmt.Fprintf(&sb, " waiting %s", t.waiting.id)
+		}
+		fmt.Fprintf(&sb, " attempt %d", t.attempts)
+		if t.status == taskSucceed && len(t.result.Counters) > 0 {
+			fmt.Fprintf(&sb, " counters %s", mr.FormatCounters(t.result.Counters))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// stageFinished tell if every task of the stage succeeded
+func (m *master) stageFinished() bool {
+	for _, t := range m.tasks {
+		if t.status != taskSucceed {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *master) stageDone(stage string) {
+	elapsed := time.Since(m.processStart)
+	m.mu.Lock()
+	m.phaseSpan.End(nil)
+	m.mu.Unlock()
+	switch {
+	case stage == "map" && m.conf.Mode == mr.ModeMapOnly:
+		m.pipelineStageDone(m.taskOutputs("m"))
+	case stage == "map":
+		m.notifyClient("map finished, elapsed time %s\n", elapsed)
+		go m.startReduceStage()
+	case stage == "reduce":
+		m.pipelineStageDone(m.taskOutputs("r"))
+	}
+}
+
+// finishJob send the final message to the client and release the master for another job
+func (m *master) finishJob(msg string, args ...any) {
+	m.notifyClient(msg, args...)
+	m.mu.Lock()
+	m.tasks = make(map[string]*task)
+	m.mapTasks = nil
+	m.mu.Unlock()
+	m.removeClient()
+	m.jobLogger.Store(nil)
+}
+
+// verifyOutputs check the files reported by a task, mappers write one file per reducer
+// with its checksum, the reducers verify them when they pull them. The outputs of the
+// stage are verified before they are the input of the next one or the output of the job
+func (m *master) verifyOutputs(stage string, files []mr.FileRef) error {
+	shuffle := stage == "map" && m.conf.Mode != mr.ModeMapOnly
+	if shuffle && len(files) != m.conf.Partitions {
+		return fmt.Errorf("expected %d partitions, got %d", m.conf.Partitions, len(files))
+	}
+	for _, f := range files {
+		if f.Checksum == nil {
+			return fmt.Errorf("missing checksum for %s", f.Name)
+		}
+		if shuffle {
+			continue
+		}
+		err := mr.VerifyRecordFile(f, m.conf.Format)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskOutputs return the files written by the tasks with the prefix in order and
+// the sum of their deltas, nil when the tasks do not report it
+func (m *master) taskOutputs(prefix string) ([]mr.FileRef, *float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	outputs := make([]mr.FileRef, 0, len(m.tasks))
+	var delta *float64
+	for i := 0; i < len(m.tasks); i++ {
+		result := m.tasks[fmt.Sprintf("%s-%d", prefix, i)].result
+		outputs = append(outputs, result.Files...)
+		if result.Delta != nil {
+			if delta == nil {
+				delta = new(float64)
+			}
+			*delta += *result.Delta
+		}
+	}
+	return outputs, delta
+}
+
+// partitionsByReducer collect the partition i of every mapper output for reducer i
+func (m *master) partitionsByReducer() [][]mr.FileRef {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make([][]mr.FileRef, m.conf.Partitions)
+	for i := 0; i < len(m.tasks); i++ {
+		t := m.tasks[fmt.Sprintf("m-%d", i)]
+		for r, part := range t.result.Files {
+			files[r] = append(files[r], part)
+		}
+	}
+	return files
+}
+
+func fileNames(refs []mr.FileRef) []string {
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.Name
+	}
+	return names
+}
@@ -0,0 +1,65 @@
+package master
+
+import (
+	"errors"
+	"fmt"
+
+	"mapreduce/internal/tracing"
+)
+
+// the spans of the master are the job, the iterations of its stages, their map and
+// reduce phases and the attempts of the tasks, sent to the workers as the
+// parents of their spans
+
+// service of the spans of the master
+const service = "master"
+
+var errCanceled = errors.New("canceled")
+
+// traceJob start the root span of the job
+func (m *master) traceJob(from int) {
+	m.jobSpan = tracing.NewSpan(m.exporter, tracing.SpanContext{}, service, "job", tracing.KindInternal)
+	m.jobSpan.SetAttributes("id", m.job.ID, "input", m.job.Input, "from", from)
+	m.job.TraceID = m.jobSpan.Context().TraceID.String()
+}
+
+// traceStage start the span of an iteration of a stage
+func (m *master) traceStage(i, iteration int) {
+	name := fmt.Sprintf("stage %d %s", i, m.conf.Job)
+	if iteration > 0 {
+		name += fmt.Sprintf(" iteration %d", iteration)
+	}
+	m.stageSpan = tracing.NewSpan(m.exporter, m.jobSpan.Context(), service, name, tracing.KindInternal)
+	m.stageSpan.SetAttributes("stage", i, "job", m.conf.Job, "mode", m.conf.Mode, "iteration", iteration,
+		"mappers", len(m.jobMappers), "reducers", len(m.jobReducers))
+}
+
+// tracePhase start the span of the map or reduce phase of the stage, the tasks of
+// the phase are its children
+func (m *master) tracePhase(phase string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phaseSpan.End(nil)
+	m.phaseSpan = tracing.NewSpan(m.exporter, m.stageSpan.Context(), service, phase, tracing.KindInternal)
+}
+
+// traceTask start the span of the attempt of a task, the parent of the span of
+// the worker
+func (m *master) traceTask(t *task) {
+	t.span = tracing.NewSpan(m.exporter, m.phaseSpan.Context(), service, "dispatch "+t.id, tracing.KindClient)
+	t.span.SetAttributes("task", t.id, "worker", t.worker.name(), "attempt", t.attempts)
+}
+
+// endStageTrace end the spans of the phase and the iteration of the stage
+func (m *master) endStageTrace(err error) {
+	m.phaseSpan.End(err)
+	m.stageSpan.End(err)
+	m.phaseSpan, m.stageSpan = nil, nil
+}
+
+// endTrace end the spans of the job
+func (m *master) endTrace(err error) {
+	m.endStageTrace(err)
+	m.jobSpan.End(err)
+	m.jobSpan = nil
+}
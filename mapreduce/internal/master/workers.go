@@ -0,0 +1,195 @@
+package master
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	n "mapreduce/internal/network"
+)
+
+// worker is a mapper or reducer connected to the master
+type worker struct {
+	id   int
+	kind string
+	conn n.Connection
+	jobs map[string]bool
+	// slots is the number of tasks the worker runs at the same time
+	slots int
+	// lost when its connection is closed, it gets no more tasks
+	lost bool
+	// registered is the time the worker connected, succeeded and
+	// failed count the attempts of its tasks
+	registered time.Time
+	succeeded  int
+	failed     int
+}
+
+func newWorker(id int, kind string, conn n.Connection, jobs []string, slots int) *worker {
+	w := &worker{
+		id:         id,
+		kind:       kind,
+		conn:       conn,
+		jobs:       make(map[string]bool, len(jobs)),
+		slots:      slots,
+		registered: time.Now(),
+	}
+	for _, job := range jobs {
+		w.jobs[job] = true
+	}
+	return w
+}
+
+// name of the worker in logs and in the dashboard, mapper 0
+func (w *worker) name() string {
+	return fmt.Sprintf("%s %d", w.kind, w.id)
+}
+
+func (w *worker) jobNames() string {
+	names := make([]string, 0, len(w.jobs))
+	for job := range w.jobs {
+		names = append(names, job)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// register a worker, the message is: register mapper|reducer job1,job2,... [slots]
+func (m *master) register(ctx context.Context, conn n.Connection, args ...string) (string, error) {
+	if len(args) < 2 {
+		return "invalid register command, expected kind and jobs", nil
+	}
+	slots := 1
+	if len(args) > 2 {
+		var err error
+		slots, err = strconv.Atoi(args[2])
+		if err != nil || slots < 1 {
+			return fmt.Sprintf("invalid register command, invalid slots %s", args[2]), nil
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kind := strings.ToLower(args[0])
+	jobs := strings.Split(args[1], ",")
+	var w *worker
+	switch kind {
+	case "mapper":
+		w = m.addMapper(conn, jobs, slots)
+	case "reducer":
+		w = m.addReducer(conn, jobs, slots)
+	default:
+		return "invalid register command", nil
+	}
+	workersGauge.Inc(w.kind)
+	n.Logger(ctx).Info("worker registered", "worker", w.name(), "slots", slots, "jobs", w.jobNames())
+	go m.watchWorker(ctx, w)
+	return fmt.Sprintf("%s accepted %d\n", w.kind, w.id), nil
+}
+
+func (m *master) addMapper(conn n.Connection, jobs []string, slots int) *worker {
+	w := newWorker(len(m.mappers), "mapper", conn, jobs, slots)
+	m.mappers = append(m.mappers, w)
+	return w
+}
+
+func (m *master) addReducer(conn n.Connection, jobs []string, slots int) *worker {
+	w := newWorker(len(m.reducers), "reducer", conn, jobs, slots)
+	m.reducers = append(m.reducers, w)
+	return w
+}
+
+// watchWorker mark the worker as lost when its connection is closed,
+// its running tasks fail and are sent again to other workers
+func (m *master) watchWorker(ctx context.Context, w *worker) {
+	<-ctx.Done()
+	m.log().Warn("worker lost", "worker", w.name())
+	workersGauge.Dec(w.kind)
+	m.mu.Lock()
+	w.lost = true
+	var running []string
+	for _, t := range m.activeTasks() {
+		if t.status != taskRunning {
+			continue
+		}
+		if t.worker == w {
+			running = append(running, t.stage+" "+t.id)
+		}
+		if t.backup == w {
+			running = append(running, t.stage+" "+t.id+backupSuffix)
+		}
+	}
+	m.mu.Unlock()
+	for _, r := range running {
+		stage, id, _ := strings.Cut(r, " ")
+		m.processTaskResult(stage, "error", id, []string{fmt.Sprintf("%s %d lost", w.kind, w.id)})
+	}
+}
+
+// slotsOf return a worker for each of their slots, the slots
+// of a worker are spread so consecutive tasks go to different workers
+func slotsOf(workers []*worker) []*worker {
+	var slots []*worker
+	for slot := 0; ; slot++ {
+		added := false
+		for _, w := range workers {
+			if slot < w.slots {
+				slots = append(slots, w)
+				added = true
+			}
+		}
+		if !added {
+			return slots
+		}
+	}
+}
+
+// workersFor return the mappers and reducers that support job
+func (m *master) workersFor(job string) (mappers, reducers []*worker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range m.mappers {
+		if w.jobs[job] && !w.lost {
+			mappers = append(mappers, w)
+		}
+	}
+	for _, w := range m.reducers {
+		if w.jobs[job] && !w.lost {
+			reducers = append(reducers, w)
+		}
+	}
+	return
+}
+
+// connected count the workers not lost
+func connected(workers []*worker) int {
+	n := 0
+	for _, w := range workers {
+		if !w.lost {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *master) status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "connected mappers %d\n", connected(m.mappers))
+	fmt.Fprintf(&sb, "connected reducers %d\n", connected(m.reducers))
+	for _, workers := range [][]*worker{m.mappers, m.reducers} {
+		for _, w := range workers {
+			fmt.Fprintf(&sb, "%s %d slots %d jobs %s", w.kind, w.id, w.slots, w.jobNames())
+			if w.lost {
+				sb.WriteString(" lost")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString(m.pipelineStatus())
+	sb.WriteString(m.tasksStatus())
+	return sb.String()
+}
@@ -0,0 +1,24 @@
+package master
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSlotsOf(t *testing.T) {
+	a := newWorker(0, "mapper", nil, []string{"wordcount"}, 3)
+	b := newWorker(1, "mapper", nil, []string{"wordcount"}, 1)
+	c := newWorker(2, "mapper", nil, []string{"wordcount"}, 2)
+	got := slotsOf([]*worker{a, b, c})
+	want := []*worker{a, b, c, a, c, a}
+	if !slices.Equal(got, want) {
+		var ids []int
+		for _, w := range got {
+			ids = append(ids, w.id)
+		}
+		t.Errorf("slots of workers %v, want 0 1 2 0 2 0", ids)
+	}
+	if slots := slotsOf(nil); len(slots) != 0 {
+		t.Errorf("slots without workers: %v", slots)
+	}
+}
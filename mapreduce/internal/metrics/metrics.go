@@ -0,0 +1,292 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// text exposition format of Prometheus, so they can be scraped from the
+// master and the workers without a client library
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets are the upper bounds in seconds of the histograms of task durations
+var DurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Default is the registry of the process, the metrics of the packages register in it
+var Default = NewRegistry()
+
+// Registry is a set of metrics with different names
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric with its series, one for each combination of the values of its labels
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// counts of each bucket of a histogram, not cumulative, and the count of all the observations
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metric %s already registered", name))
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	// metrics without labels are written from the start
+	if len(labels) == 0 {
+		f.get(nil)
+	}
+	r.families[name] = f
+	return f
+}
+
+// get the series with the label values, created the first time
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) add(delta float64, values []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(values).value += delta
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	f *family
+}
+
+// NewCounter register a counter with the names of its labels
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, "counter", labels, nil)}
+}
+
+// Inc add one to the series with the label values
+func (c *Counter) Inc(values ...string) {
+	c.f.add(1, values)
+}
+
+// Add a positive delta to the series with the label values
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can not decrease", c.f.name))
+	}
+	c.f.add(delta, values)
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	f *family
+}
+
+// NewGauge register a gauge with the names of its labels
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, "gauge", labels, nil)}
+}
+
+// Set the series with the label values
+func (g *Gauge) Set(value float64, values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.get(values).value = value
+}
+
+// Add delta to the series with the label values
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.f.add(delta, values)
+}
+
+// Inc add one to the series with the label values
+func (g *Gauge) Inc(values ...string) {
+	g.f.add(1, values)
+}
+
+// Dec subtract one from the series with the label values
+func (g *Gauge) Dec(values ...string) {
+	g.f.add(-1, values)
+}
+
+// Histogram count observations in buckets of upper bounds
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram register a histogram with the upper bounds of its buckets in increasing order,
+// the bucket +Inf is added
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of %s are not sorted", name))
+	}
+	return &Histogram{f: r.register(name, help, "histogram", labels, buckets)}
+}
+
+// Observe a value in the series with the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(values)
+	i := sort.SearchFloat64s(h.f.buckets, value)
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.value += value
+}
+
+// NewCounter register a counter in the Default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewGauge register a gauge in the Default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewHistogram register a histogram in the Default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// WriteTo write the metrics in the text exposition format, sorted by name and labels
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			writeSample(buf, f.name, f.labels, s.values, s.value)
+			continue
+		}
+		labels := append(append([]string(nil), f.labels...), "le")
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			writeSample(buf, f.name+"_bucket", labels, append(s.values, formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(buf, f.name+"_bucket", labels, append(s.values, "+Inf"), float64(s.count))
+		writeSample(buf, f.name+"_sum", f.labels, s.values, s.value)
+		writeSample(buf, f.name+"_count", f.labels, s.values, float64(s.count))
+	}
+}
+
+// writeSample write a line name{label="value",...} value
+func writeSample(buf *bytes.Buffer, name string, labels, values []string, value float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			fmt.Fprintf(buf, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// ServeHTTP write the metrics of the registry, to be scraped on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func exposition(t *testing.T, r *Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	tasks := r.NewCounter("tasks_total", "tasks started\nby stage", "stage")
+	tasks.Inc("map")
+	tasks.Add(2, "reduce")
+	tasks.Inc("map")
+	workers := r.NewGauge("workers", `workers connected, a \ path`)
+	workers.Inc()
+	workers.Inc()
+	workers.Dec()
+	files := r.NewGauge("files", "files by name", "name")
+	files.Set(1, "a \"quoted\"\\name\nnext")
+	want := `# HELP files files by name
+# TYPE files gauge
+files{name="a \"quoted\"\\name\nnext"} 1
+# HELP tasks_total tasks started\nby stage
+# TYPE tasks_total counter
+tasks_total{stage="map"} 2
+tasks_total{stage="reduce"} 2
+# HELP workers workers connected, a \\ path
+# TYPE workers gauge
+workers 1
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("duration_seconds", "duration of the tasks", []float64{0.1, 1}, "stage")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v, "map")
+	}
+	want := `# HELP duration_seconds duration of the tasks
+# TYPE duration_seconds histogram
+duration_seconds_bucket{stage="map",le="0.1"} 2
+duration_seconds_bucket{stage="map",le="1"} 3
+duration_seconds_bucket{stage="map",le="+Inf"} 4
+duration_seconds_sum{stage="map"} 3.65
+duration_seconds_count{stage="map"} 4
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// a histogram without labels is written before any observation
+func TestEmptyHistogram(t *testing.T) {
+	r := NewRegistry()
+	r.NewHistogram("wait_seconds", "wait", []float64{1})
+	want := `# HELP wait_seconds wait
+# TYPE wait_seconds histogram
+wait_seconds_bucket{le="1"} 0
+wait_seconds_bucket{le="+Inf"} 0
+wait_seconds_sum 0
+wait_seconds_count 0
+`
+	if got := exposition(t, r); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "requests").Inc()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("content type %q, want %q", ct, ContentType)
+	}
+	if !strings.Contains(rec.Body.String(), "\nrequests_total 1\n") {
+		t.Errorf("body without the counter:\n%s", rec.Body.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("tasks_total", "tasks")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic registering a metric twice")
+		}
+	}()
+	r.NewGauge("tasks_total", "tasks")
+}
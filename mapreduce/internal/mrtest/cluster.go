@@ -0,0 +1,381 @@
+// Package mrtest runs a master and its workers inside a test, on loopback
+// ports, to submit jobs and check their output and the messages of the protocol.
+// Workers talk to the master through a proxy that records the messages and
+// injects faults: dropped connections, slow workers and corrupted files
+package mrtest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/master"
+	n "mapreduce/internal/network"
+	"mapreduce/internal/tracing"
+)
+
+// Options of a cluster, zero values take the defaults
+type Options struct {
+	// Mappers and Reducers are the number of workers, 1 by default
+	Mappers  int
+	Reducers int
+	// Slots of every worker, 1 by default
+	Slots int
+	// Jobs run by the workers, all the registered jobs by default. mrtest does not
+	// import the built-in jobs, so their own tests can use it, import them to run them
+	Jobs []string
+	// MaxAttempts of a task, 3 by default
+	MaxAttempts int
+	// Defaults are key=value options of every job, the job is wordcount by default
+	Defaults []string
+	// Workdir of the master, a temporary directory of the test by default
+	Workdir string
+	// Timeout of the commands, 30s by default
+	Timeout time.Duration
+}
+
+// Message of the protocol between the master and a worker
+type Message struct {
+	// Worker is mapper-N or reducer-N
+	Worker     string
+	FromMaster bool
+	Line       string
+}
+
+func (m Message) String() string {
+	if m.FromMaster {
+		return fmt.Sprintf("master -> %s: %s", m.Worker, m.Line)
+	}
+	return fmt.Sprintf("%s -> master: %s", m.Worker, m.Line)
+}
+
+// Cluster is a master with its workers, stopped when the test ends
+type Cluster struct {
+	// Addr is the address of the master
+	Addr    string
+	Workdir string
+	// Master is the handler of the master, an http.Handler of its dashboard
+	Master n.Handler
+	// Traces collect the spans of the master and the workers of the cluster,
+	// see tracing.Collector.Tree
+	Traces *tracing.Collector
+
+	t        testing.TB
+	opts     Options
+	listener n.Listener
+	mappers  []*Worker
+	reducers []*Worker
+
+	mu       sync.Mutex
+	messages []Message
+	changed  chan struct{}
+}
+
+// Start a master and its workers, the test fails if they can not start
+func Start(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	opts = withDefaults(t, opts)
+	defaults, err := mr.ParseJobConfig(append([]string{"job=wordcount"}, opts.Defaults...))
+	if err != nil {
+		t.Fatalf("invalid job options: %s", err)
+	}
+	listener, err := n.TCP.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can not listen: %s", err)
+	}
+	c := &Cluster{
+		Addr:     listener.Addr(),
+		Workdir:  opts.Workdir,
+		t:        t,
+		opts:     opts,
+		listener: listener,
+		changed:  make(chan struct{}),
+		Traces:   tracing.NewCollector(),
+	}
+	c.Master = master.New(c.Addr, defaults, opts.MaxAttempts, opts.Workdir, c.Traces)
+	server := n.NewServer("master", c.Addr, c.Master)
+	go server.Serve(listener)
+	t.Cleanup(c.stop)
+	for i := 0; i < opts.Mappers; i++ {
+		c.mappers = append(c.mappers, c.startWorker("mapper", i))
+	}
+	for i := 0; i < opts.Reducers; i++ {
+		c.reducers = append(c.reducers, c.startWorker("reducer", i))
+	}
+	return c
+}
+
+func withDefaults(t testing.TB, opts Options) Options {
+	if opts.Mappers == 0 {
+		opts.Mappers = 1
+	}
+	if opts.Reducers == 0 {
+		opts.Reducers = 1
+	}
+	if opts.Slots == 0 {
+		opts.Slots = 1
+	}
+	if len(opts.Jobs) == 0 {
+		opts.Jobs = mr.RegisteredJobs()
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Workdir == "" {
+		opts.Workdir = t.TempDir()
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return opts
+}
+
+// startWorker connect a worker to the master through its proxy and wait until it is registered
+func (c *Cluster) startWorker(kind string, i int) *Worker {
+	c.t.Helper()
+	w, err := newWorker(c, fmt.Sprintf("%s-%d", kind, i))
+	if err != nil {
+		c.t.Fatalf("can not start %s %d: %s", kind, i, err)
+	}
+	newServer := mr.NewMapServer
+	if kind == "reducer" {
+		newServer = mr.NewReduceServer
+	}
+	w.server, err = newServer(w.Addr(), c.opts.Slots, c.opts.Jobs...)
+	if err != nil {
+		c.t.Fatalf("can not start %s %d: %s", kind, i, err)
+	}
+	w.server.Exporter = c.Traces
+	go w.server.Run()
+	c.WaitMessage(fmt.Sprintf("^%s accepted %d$", kind, i))
+	return w
+}
+
+// Mapper return the worker mapper-i
+func (c *Cluster) Mapper(i int) *Worker {
+	return c.mappers[i]
+}
+
+// Reducer return the worker reducer-i
+func (c *Cluster) Reducer(i int) *Worker {
+	return c.reducers[i]
+}
+
+func (c *Cluster) stop() {
+	for _, w := range append(c.mappers, c.reducers...) {
+		w.Drop()
+		w.listener.Close()
+	}
+	c.listener.Close()
+}
+
+// Run send a command to the master as a client and return the messages it
+// receives until the final one, when the job finishes or fails
+func (c *Cluster) Run(cmd string) []string {
+	c.t.Helper()
+	var lines []string
+	err := c.command(cmd, func(line string) bool {
+		lines = append(lines, line)
+		return !isFinal(line)
+	})
+	if err != nil {
+		c.t.Fatalf("%s: %s, received %q", cmd, err, lines)
+	}
+	return lines
+}
+
+// Status return the response of the status command
+func (c *Cluster) Status() string {
+	c.t.Helper()
+	var lines []string
+	err := c.command("status", func(line string) bool {
+		lines = append(lines, line)
+		return true
+	})
+	if err != nil && len(lines) == 0 {
+		c.t.Fatalf("status: %s", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// command send cmd on a new connection and read lines while next returns true,
+// the status command has no final line so reading stops after an idle period
+func (c *Cluster) command(cmd string, next func(line string) bool) error {
+	conn, err := net.Dial(n.PROTO, c.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s\n", cmd)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(c.opts.Timeout)
+	reader := bufio.NewReader(conn)
+	for {
+		idle := 200 * time.Millisecond
+		if cmd != "status" || time.Now().Add(idle).After(deadline) {
+			idle = time.Until(deadline)
+		}
+		conn.SetReadDeadline(time.Now().Add(idle))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !next(strings.TrimSpace(line)) {
+			return nil
+		}
+	}
+}
+
+// isFinal tell if the line is the last message the client of a job receives
+func isFinal(line string) bool {
+	for _, final := range []string{"finished elapsed time", "job failed", "can't process", "invalid", "server is busy", "nothing to resume"} {
+		if strings.Contains(line, final) {
+			return true
+		}
+	}
+	return false
+}
+
+// Messages seen between the master and the workers, in the order they were forwarded
+func (c *Cluster) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
+
+// WaitMessage wait until a message matches the regular expression and return it
+func (c *Cluster) WaitMessage(expr string) Message {
+	c.t.Helper()
+	re := regexp.MustCompile(expr)
+	timeout := time.After(c.opts.Timeout)
+	seen := 0
+	for {
+		c.mu.Lock()
+		messages, changed := c.messages[seen:], c.changed
+		seen = len(c.messages)
+		c.mu.Unlock()
+		for _, m := range messages {
+			if re.MatchString(m.Line) {
+				return m
+			}
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			c.t.Fatalf("timeout waiting for a message matching %s", expr)
+		}
+	}
+}
+
+func (c *Cluster) record(m Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, m)
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Output return the lines of the files of the work directory matching
+// the glob, r-out-*.txt for the output of a job, ordered by name
+func (c *Cluster) Output(glob string) []string {
+	c.t.Helper()
+	fnames, err := filepath.Glob(filepath.Join(c.Workdir, glob))
+	if err != nil {
+		c.t.Fatalf("invalid glob %s: %s", glob, err)
+	}
+	sort.Slice(fnames, func(i, j int) bool {
+		if len(fnames[i]) != len(fnames[j]) {
+			return len(fnames[i]) < len(fnames[j])
+		}
+		return fnames[i] < fnames[j]
+	})
+	var lines []string
+	for _, fname := range fnames {
+		lines = append(lines, ReadLines(c.t, fname)...)
+	}
+	return lines
+}
+
+// OutputFiles of a job, the master lists them in the message before the final one
+func OutputFiles(t testing.TB, lines []string) []mr.FileRef {
+	t.Helper()
+	for _, line := range lines {
+		if refs, ok := strings.CutPrefix(line, "output "); ok {
+			files, err := mr.ParseFileRefs(refs)
+			if err != nil {
+				t.Fatalf("invalid output %q: %s", line, err)
+			}
+			return files
+		}
+	}
+	t.Fatalf("no output in %q", lines)
+	return nil
+}
+
+// ReadOutput verify the output files of a job and read their records as csv lines,
+// format is the one of the job, compressed files are detected
+func ReadOutput(t testing.TB, lines []string, format string) []string {
+	t.Helper()
+	f, err := mr.FormatByName(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := mr.ReadOutput[string, string](OutputFiles(t, lines), f, nil)
+	if err != nil {
+		t.Fatalf("can not read the output: %s", err)
+	}
+	var sb strings.Builder
+	writer := csv.NewWriter(&sb)
+	for _, p := range pairs {
+		_ = writer.Write([]string{p.Key, p.Value})
+	}
+	writer.Flush()
+	return strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+}
+
+// ReadLines of a file, compressed files are detected
+func ReadLines(t testing.TB, fname string) []string {
+	t.Helper()
+	in, err := mr.NewTextFileIterator(fname)
+	if err != nil {
+		t.Fatalf("can not read %s: %s", fname, err)
+	}
+	defer in.Close()
+	var lines []string
+	for in.Next() {
+		lines = append(lines, in.Value())
+	}
+	if err := in.Error(); err != nil {
+		t.Fatalf("can not read %s: %s", fname, err)
+	}
+	return lines
+}
+
+// AssertGolden check the lines are the ones of the golden file,
+// the file is written instead when the environment sets MRTEST_UPDATE
+func AssertGolden(t testing.TB, lines []string, golden string) {
+	t.Helper()
+	if os.Getenv("MRTEST_UPDATE") != "" {
+		err := os.WriteFile(golden, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+		if err != nil {
+			t.Fatalf("can not update %s: %s", golden, err)
+		}
+		return
+	}
+	want := ReadLines(t, golden)
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("output does not match %s\ngot:\n%s\nwant:\n%s", golden, strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,532 @@
+package mrtest_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "mapreduce/internal/jobs"
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/mrtest"
+	n "mapreduce/internal/network"
+	"mapreduce/internal/tracing"
+)
+
+const (
+	wordcountInput  = "../jobs/testdata/wordcount/input.txt"
+	wordcountGolden = "../jobs/testdata/wordcount/output.golden"
+	pagerankInput   = "../jobs/testdata/pagerank/input.txt"
+	pagerankGolden  = "../jobs/testdata/pagerank/output.golden"
+)
+
+func input(t *testing.T, fname string) string {
+	t.Helper()
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+// sent return the commands of the master to the workers starting with prefix
+func sent(c *mrtest.Cluster, prefix string) []mrtest.Message {
+	var messages []mrtest.Message
+	for _, m := range c.Messages() {
+		if m.FromMaster && strings.HasPrefix(m.Line, prefix) {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// the reducer finds the corrupt partition, the map that wrote it runs again and
+// the reduce runs with its new partitions
+func TestCorruptMapOutputRunsMapAgain(t *testing.T) {
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	c.Mapper(0).CorruptOutput("m-0")
+	lines := c.Run("process " + input(t, wordcountInput))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	if maps := sent(c, "map m-0 "); len(maps) != 2 {
+		t.Errorf("map m-0 sent %d times, want 2", len(maps))
+	}
+	if maps := sent(c, "map m-1 "); len(maps) != 1 {
+		t.Errorf("map m-1 sent %d times, want 1", len(maps))
+	}
+	if reduces := sent(c, "reduce r-0 "); len(reduces) != 2 {
+		t.Errorf("reduce r-0 sent %d times, want 2", len(reduces))
+	}
+	corrupt := 0
+	for _, m := range c.Messages() {
+		if !m.FromMaster && strings.HasPrefix(m.Line, "reduce corrupt r-0 ") {
+			corrupt++
+		}
+	}
+	if corrupt != 1 {
+		t.Errorf("reducer reported %d corrupt partitions, want 1", corrupt)
+	}
+	mrtest.AssertGolden(t, c.Output("r-out-*.txt"), wordcountGolden)
+}
+
+// the job fails when the map that wrote a corrupt partition has no attempt left
+func TestCorruptMapOutputFailsLastAttempt(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{MaxAttempts: 1})
+	c.Mapper(0).CorruptOutput("m-0")
+	lines := c.Run("process " + input(t, wordcountInput))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "job failed, map task m-0: corrupt partition ") {
+		t.Fatalf("unexpected final message %q", last)
+	}
+}
+
+// a stage reads the records of the previous one whatever their format and compression
+func TestPipelineReadsPreviousFormat(t *testing.T) {
+	var want []string
+	// subtests are named by format, the work directory must not have commas
+	for _, first := range [][2]string{{"csv", "none"}, {"jsonl", "gzip"}, {"binary", "zlib"}} {
+		t.Run(first[0], func(t *testing.T) {
+			c := mrtest.Start(t, mrtest.Options{Mappers: 2, Reducers: 2})
+			lines := c.Run("pipeline " + input(t, wordcountInput) + " job=wordcount,format=" + first[0] + ",compress-output=" + first[1] + " job=wordcount")
+			if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+				t.Fatalf("pipeline did not finish: %q", lines)
+			}
+			got := mrtest.ReadOutput(t, lines, "csv")
+			if want == nil {
+				want = got
+			} else if !slices.Equal(got, want) {
+				t.Errorf("output differs from the csv pipeline\ngot:  %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+// a failed iteration is resumed from the outputs of the last one, it does not overwrite them
+func TestFailedIterationResumes(t *testing.T) {
+	c := mrtest.Start(t, mrtest.Options{MaxAttempts: 1})
+	// the reducer is slow so the fault is set before the iteration 2 is done
+	c.Reducer(0).Delay(20 * time.Millisecond)
+	done := make(chan []string)
+	go func() {
+		done <- c.Run("process " + input(t, pagerankInput) + " job=pagerank iterations=50 epsilon=0.0001")
+	}()
+	// the third reduce is the one of the iteration 2
+	for deadline := time.Now().Add(10 * time.Second); len(sent(c, "reduce r-0 ")) < 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the iteration 2")
+		}
+	}
+	c.Reducer(0).CorruptOutput("r-0")
+	lines := <-done
+	if last := lines[len(lines)-1]; !strings.Contains(last, "job failed") {
+		t.Fatalf("job did not fail: %q", lines)
+	}
+	c.Reducer(0).Delay(0)
+	lines = c.Run("resume")
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("resume did not finish: %q", lines)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), pagerankGolden)
+	// the files of the previous iterations are removed
+	outputs, _ := filepath.Glob(filepath.Join(c.Workdir, "*r-out-*"))
+	if len(outputs) != 1 {
+		t.Errorf("expected the output of the last iteration only, got %v", outputs)
+	}
+}
+
+// the spans of a job form a single tree across the master and the workers
+func TestTraceParents(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{})
+	lines := c.Run("process " + input(t, wordcountInput))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	roots := c.Traces.Roots()
+	if len(roots) != 1 || roots[0].Name != "job" {
+		t.Fatalf("expected the job as the only root, got %v", roots)
+	}
+	byName := map[string]tracing.SpanData{}
+	for _, s := range c.Traces.Trace(roots[0].TraceID) {
+		byName[s.Service+": "+s.Name] = s
+	}
+	// each span and its parent
+	parents := [][2]string{
+		{"master: stage 0 wordcount", "master: job"},
+		{"master: map", "master: stage 0 wordcount"},
+		{"master: dispatch m-0", "master: map"},
+		{"mapper 0: map m-0", "master: dispatch m-0"},
+		{"master: reduce", "master: stage 0 wordcount"},
+		{"master: dispatch r-0", "master: reduce"},
+		{"reducer 0: reduce r-0", "master: dispatch r-0"},
+		{"reducer 0: shuffle", "reducer 0: reduce r-0"},
+		{"reducer 0: reduce", "reducer 0: reduce r-0"},
+	}
+	for _, p := range parents {
+		child, ok := byName[p[0]]
+		if !ok {
+			t.Errorf("no span %s in\n%s", p[0], c.Traces.Tree(roots[0].TraceID))
+			continue
+		}
+		if parent := byName[p[1]]; child.Parent != parent.SpanID || !parent.SpanID.IsValid() {
+			t.Errorf("parent of %s is %s, want %s %s", p[0], child.Parent, p[1], parent.SpanID)
+		}
+	}
+	if kind := byName["master: dispatch m-0"].Kind; kind != tracing.KindClient {
+		t.Errorf("dispatch is a %s span, want client", kind)
+	}
+	if kind := byName["mapper 0: map m-0"].Kind; kind != tracing.KindServer {
+		t.Errorf("map is a %s span, want server", kind)
+	}
+}
+
+// the messages of a job follow the protocol, maps before reduces and a result for each task
+func TestWordcountProtocol(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	lines := c.Run("process " + input(t, wordcountInput))
+	if lines[0] != "ok, processing" {
+		t.Errorf("first message %q, want ok, processing", lines[0])
+	}
+	if last := lines[len(lines)-1]; !strings.Contains(last, "reduce finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), wordcountGolden)
+	lastMapDone, firstReduce := -1, -1
+	results := map[string]int{}
+	for i, m := range c.Messages() {
+		fields := strings.Fields(m.Line)
+		switch {
+		case m.FromMaster && (fields[0] == "map" || fields[0] == "reduce"):
+			// map m-0 <in> <out prefix> <options> and reduce r-0 <partitions> <out> <options>
+			if len(fields) < 5 || !strings.Contains(m.Line, " job=wordcount") || !strings.Contains(m.Line, " traceparent=00-") {
+				t.Errorf("invalid command to %s: %q", m.Worker, m.Line)
+			}
+			if fields[0] == "reduce" && firstReduce < 0 {
+				firstReduce = i
+			}
+			if want := map[string]string{"map": "mapper-", "reduce": "reducer-"}[fields[0]]; !strings.HasPrefix(m.Worker, want) {
+				t.Errorf("%s sent to %s", m.Line, m.Worker)
+			}
+		case !m.FromMaster && len(fields) > 2 && fields[1] == "done":
+			results[fields[0]+" "+fields[2]]++
+			if fields[0] == "map" {
+				lastMapDone = i
+			}
+		}
+	}
+	if firstReduce < lastMapDone {
+		t.Errorf("reduce sent at message %d before the last map done at %d", firstReduce, lastMapDone)
+	}
+	for _, task := range []string{"map m-0", "map m-1", "reduce r-0"} {
+		if results[task] != 1 {
+			t.Errorf("%s done %d times, want 1", task, results[task])
+		}
+	}
+	if status := c.Status(); !strings.Contains(status, "stage 0 wordcount succeed") {
+		t.Errorf("status does not show the stage succeeded:\n%s", status)
+	}
+}
+
+// the tasks of a worker that loses its connection run on another one
+func TestDroppedMapperTaskRunsAgain(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	// the results of the mappers are held back so the first one is dropped with its task running
+	c.Mapper(0).Delay(time.Second)
+	c.Mapper(1).Delay(100 * time.Millisecond)
+	done := make(chan []string)
+	go func() {
+		done <- c.Run("process " + input(t, wordcountInput))
+	}()
+	c.WaitMessage(`^map m-0 `)
+	c.Mapper(0).Drop()
+	lines := <-done
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	maps := sent(c, "map m-0 ")
+	if len(maps) != 2 || maps[0].Worker != "mapper-0" || maps[1].Worker != "mapper-1" {
+		t.Errorf("map m-0 sent to %v, want mapper-0 then mapper-1", maps)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), wordcountGolden)
+	status := c.Status()
+	if !strings.Contains(status, "connected mappers 1\n") || !strings.Contains(status, "mapper 0 slots 1") {
+		t.Errorf("the lost mapper is counted as connected:\n%s", status)
+	}
+}
+
+// a slow worker delays the job but its tasks are not retried
+func TestDelayedReducer(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{})
+	c.Reducer(0).Delay(50 * time.Millisecond)
+	started := time.Now()
+	lines := c.Run("process " + input(t, wordcountInput))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	if elapsed := time.Since(started); elapsed < 50*time.Millisecond {
+		t.Errorf("job finished in %s, before the reducer answered", elapsed)
+	}
+	if reduces := sent(c, "reduce r-0 "); len(reduces) != 1 {
+		t.Errorf("reduce r-0 sent %d times, want 1", len(reduces))
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), wordcountGolden)
+}
+
+// a corrupt reducer output fails the attempt, the reduce runs again
+func TestCorruptReduceOutputRetries(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{})
+	c.Reducer(0).CorruptOutput("r-0")
+	lines := c.Run("process " + input(t, wordcountInput))
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	if reduces := sent(c, "reduce r-0 "); len(reduces) != 2 {
+		t.Errorf("reduce r-0 sent %d times, want 2", len(reduces))
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), wordcountGolden)
+}
+
+// the job fails when every attempt of a task fails
+func TestCorruptOutputFailsLastAttempt(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{MaxAttempts: 1})
+	c.Reducer(0).CorruptOutput("r-0")
+	lines := c.Run("process " + input(t, wordcountInput))
+	last := lines[len(lines)-1]
+	if !strings.Contains(last, "job failed, reduce task r-0: ") {
+		t.Fatalf("unexpected final message %q", last)
+	}
+	if status := c.Status(); !strings.Contains(status, "stage 0 wordcount failed") {
+		t.Errorf("status does not show the stage failed:\n%s", status)
+	}
+}
+
+// a backup attempt of a slow task runs on an idle worker, the first attempt done wins
+func TestBackupTaskWins(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	// the result of the first mapper comes after the one of its backup
+	c.Mapper(0).Delay(time.Second)
+	lines := c.Run("process " + input(t, wordcountInput) + " backup-after=50ms")
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, lines, "csv"), wordcountGolden)
+	backups := sent(c, "map m-0-backup ")
+	if len(backups) != 1 || backups[0].Worker != "mapper-1" {
+		t.Fatalf("backup of m-0 sent to %v, want mapper-1", backups)
+	}
+	if cancels := sent(c, "cancel m-0"); len(cancels) != 1 || cancels[0].Worker != "mapper-0" {
+		t.Errorf("cancel of m-0 sent to %v, want mapper-0", cancels)
+	}
+	// the reducer reads the partition of the backup
+	reduces := sent(c, "reduce r-0 ")
+	if len(reduces) != 1 || !strings.Contains(reduces[0].Line, "m-out-0-backup-0.txt#") {
+		t.Errorf("reduce does not read the backup partition: %v", reduces)
+	}
+}
+
+// a job whose client disconnects fails at its next stage, the master takes other jobs
+func TestClientDisconnectFailsJob(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{})
+	// the first stage is still running when the client leaves
+	c.Reducer(0).Delay(100 * time.Millisecond)
+	conn, err := net.Dial(n.PROTO, c.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "pipeline %s job=wordcount job=wordcount\n", input(t, wordcountInput))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.HasPrefix(line, "ok, processing") {
+		t.Fatalf("pipeline not started: %q %v", line, err)
+	}
+	conn.Close()
+	c.Reducer(0).Delay(0)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		lines := c.Run("process " + input(t, wordcountInput))
+		last := lines[len(lines)-1]
+		if strings.Contains(last, "finished elapsed time") {
+			break
+		}
+		if !strings.Contains(last, "server is busy") || time.Now().After(deadline) {
+			t.Fatalf("unexpected final message %q", last)
+		}
+	}
+	if status := c.Status(); !strings.Contains(status, "stage 0 wordcount succeed") {
+		t.Errorf("status does not show the last job:\n%s", status)
+	}
+}
+
+// counters reported by name=value fields after the word counters of line
+func countersOf(t *testing.T, line string) map[string]int64 {
+	t.Helper()
+	_, fields, ok := strings.Cut(line, "counters ")
+	if !ok {
+		t.Fatalf("no counters in %q", line)
+	}
+	counters := map[string]int64{}
+	for _, field := range strings.Fields(fields) {
+		name, value, _ := strings.Cut(field, "=")
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid counter %q: %s", field, err)
+		}
+		counters[name] = v
+	}
+	return counters
+}
+
+// the counters of the tasks are summed for each stage and for the job
+func TestCountersSummed(t *testing.T) {
+	t.Parallel()
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	lines := c.Run("pipeline " + input(t, wordcountInput) + " job=wordcount job=wordcount")
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("pipeline did not finish: %q", lines)
+	}
+	// the tasks of the second stage are sent after the reduce of the first one is done
+	stages := []map[string]int64{{}, {}}
+	stage := 0
+	tasks := 0
+	for _, m := range c.Messages() {
+		fields := strings.Fields(m.Line)
+		if m.FromMaster || len(fields) < 4 || fields[1] != "done" {
+			continue
+		}
+		if fields[0] == "map" && stage == 0 && stages[0][mr.CounterReduceOutputRecords] > 0 {
+			stage = 1
+		}
+		result, err := mr.ParseTaskResult(fields[3:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages[stage] = mr.AddCounters(stages[stage], result.Counters)
+		tasks++
+	}
+	if tasks < 4 {
+		t.Fatalf("%d tasks done, want the maps and reduce of both stages", tasks)
+	}
+	// 3 lines of text, 11 distinct words read by the second stage
+	if n := stages[0][mr.CounterMapInputLines]; n != 3 {
+		t.Errorf("stage 0 %s = %d, want 3", mr.CounterMapInputLines, n)
+	}
+	if n := stages[1][mr.CounterMapInputLines]; n != 11 {
+		t.Errorf("stage 1 %s = %d, want 11", mr.CounterMapInputLines, n)
+	}
+	status := strings.Split(c.Status(), "\n")
+	for i, want := range stages {
+		prefix := fmt.Sprintf("stage %d wordcount succeed", i)
+		j := slices.IndexFunc(status, func(line string) bool { return strings.HasPrefix(line, prefix) })
+		if j < 0 {
+			t.Fatalf("%s not in status %q", prefix, status)
+		}
+		if got := countersOf(t, status[j]); !maps.Equal(got, want) {
+			t.Errorf("stage %d counters %v, want the sum of its tasks %v", i, got, want)
+		}
+	}
+	j := slices.IndexFunc(lines, func(line string) bool { return strings.HasPrefix(line, "counters ") })
+	if j < 0 {
+		t.Fatalf("counters not sent to the client: %q", lines)
+	}
+	if got, want := countersOf(t, lines[j]), mr.AddCounters(mr.AddCounters(nil, stages[0]), stages[1]); !maps.Equal(got, want) {
+		t.Errorf("job counters %v, want the sum of the stages %v", got, want)
+	}
+}
+
+// blockingJob report its progress and wait for its task to be canceled, its
+// backup attempt waits for release and counts the words of the line
+const blockingJob = "mrtest-blocking"
+
+var blocking struct {
+	release chan struct{}
+	stopped chan error
+}
+
+func init() {
+	mr.Register(mr.NewContextJob[string, string, string, int, int](blockingJob, blockingMapper{}, nil, sumReducer{}))
+}
+
+type blockingMapper struct{}
+
+func (blockingMapper) LineToRecords(ctx *mr.TaskContext, line string) ([]string, error) {
+	if ctx.Task == "m-0" {
+		ctx.Progress(0.5)
+		<-ctx.Done()
+		blocking.stopped <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	<-blocking.release
+	return strings.Fields(line), nil
+}
+
+func (blockingMapper) Map(_ *mr.TaskContext, word string) (mr.Pair[string, int], error) {
+	return mr.Pair[string, int]{Key: word, Value: 1}, nil
+}
+
+type sumReducer struct{}
+
+func (sumReducer) Reduce(_ *mr.TaskContext, key string, values []int) ([]mr.Pair[string, int], error) {
+	return []mr.Pair[string, int]{{Key: key, Value: len(values)}}, nil
+}
+
+// the progress of a running task is in the status, the task stops when the master cancels it
+func TestCancelRunningTask(t *testing.T) {
+	blocking.release = make(chan struct{})
+	blocking.stopped = make(chan error, 1)
+	c := mrtest.Start(t, mrtest.Options{Mappers: 2})
+	fname := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(fname, []byte("b a b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []string)
+	go func() {
+		done <- c.Run("process " + fname + " job=" + blockingJob + " backup-after=10ms")
+	}()
+	progress := c.WaitMessage(`^map progress m-0 0\.50$`)
+	// the message is recorded before the master reads it
+	re := regexp.MustCompile(`task m-0 mapper \d running 50%`)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status := c.Status()
+		if re.MatchString(status) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status does not show the progress of m-0:\n%s", status)
+		}
+	}
+	c.WaitMessage(`^map m-0-backup `)
+	close(blocking.release)
+	select {
+	case err := <-blocking.stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("task stopped with %v, want canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the canceled task is still running")
+	}
+	lines := <-done
+	if last := lines[len(lines)-1]; !strings.Contains(last, "finished elapsed time") {
+		t.Fatalf("job did not finish: %q", lines)
+	}
+	if cancels := sent(c, "cancel m-0"); len(cancels) != 1 || cancels[0].Worker != progress.Worker {
+		t.Errorf("cancel of m-0 sent to %v, want %s", cancels, progress.Worker)
+	}
+	if got := mrtest.ReadOutput(t, lines, mr.FormatCSV); !slices.Equal(got, []string{"a,1", "b,2"}) {
+		t.Errorf("output %q, want the counts of the backup attempt", got)
+	}
+}
@@ -0,0 +1,156 @@
+package mrtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mr "mapreduce/internal/mapreduce"
+	n "mapreduce/internal/network"
+)
+
+// Worker is a mapper or a reducer of a cluster, connected to the master
+// through a proxy that records its messages and injects faults
+type Worker struct {
+	Name string
+
+	cluster  *Cluster
+	listener net.Listener
+	server   *mr.StageServer
+
+	mu      sync.Mutex
+	conns   []net.Conn
+	delay   time.Duration
+	corrupt map[string]bool
+}
+
+func newWorker(c *Cluster, name string) (*Worker, error) {
+	listener, err := net.Listen(n.PROTO, "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	w := &Worker{
+		Name:     name,
+		cluster:  c,
+		listener: listener,
+		corrupt:  map[string]bool{},
+	}
+	go w.accept()
+	return w, nil
+}
+
+// Addr of the proxy the worker connects to
+func (w *Worker) Addr() string {
+	return w.listener.Addr().String()
+}
+
+// Drop close the connection of the worker with the master, the master
+// sends its running tasks to other workers
+func (w *Worker) Drop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, conn := range w.conns {
+		conn.Close()
+	}
+	w.conns = nil
+}
+
+// Delay the messages of the worker to the master, as a slow worker
+func (w *Worker) Delay(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.delay = d
+}
+
+// CorruptOutput change a byte of the first file reported by the task the next
+// time it is done, before the master or a reducer verify it
+func (w *Worker) CorruptOutput(task string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.corrupt[task] = true
+}
+
+// accept the connection of the worker and forward its messages to the master
+func (w *Worker) accept() {
+	conn, err := w.listener.Accept()
+	if err != nil {
+		return
+	}
+	master, err := net.Dial(n.PROTO, w.cluster.Addr)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	w.mu.Lock()
+	w.conns = append(w.conns, conn, master)
+	w.mu.Unlock()
+	go w.forward(master, conn, true)
+	w.forward(conn, master, false)
+}
+
+// forward the lines read from src to dst, recording them
+func (w *Worker) forward(src, dst net.Conn, fromMaster bool) {
+	defer src.Close()
+	defer dst.Close()
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if !fromMaster {
+			w.beforeMaster(line)
+		}
+		w.cluster.record(Message{Worker: w.Name, FromMaster: fromMaster, Line: strings.TrimSpace(line)})
+		_, err = dst.Write([]byte(line))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// beforeMaster apply the faults to a message of the worker
+func (w *Worker) beforeMaster(line string) {
+	w.mu.Lock()
+	delay := w.delay
+	w.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	// map done m-0 files... or reduce done r-0 file...
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[1] != "done" {
+		return
+	}
+	w.mu.Lock()
+	corrupt := w.corrupt[fields[2]]
+	delete(w.corrupt, fields[2])
+	w.mu.Unlock()
+	if !corrupt {
+		return
+	}
+	refs, err := mr.ParseFileRefs(fields[3])
+	if err == nil && len(refs) > 0 {
+		err = corruptFile(refs[0].Name)
+	}
+	if err != nil {
+		w.cluster.t.Errorf("can not corrupt output of %s: %s", fields[2], err)
+	}
+}
+
+// corruptFile flip the bits of the byte in the middle of the file
+func corruptFile(fname string) error {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%s is empty", fname)
+	}
+	data[len(data)/2] ^= 0xff
+	return os.WriteFile(fname, data, 0644)
+}
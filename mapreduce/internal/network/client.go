@@ -1,11 +1,7 @@
 package network
 
-import "net"
-
-func NewClient(address string) (Connection, error) {
-	dial, err := net.Dial(PROTO, address)
-	if err != nil {
-		return nil, err
-	}
-	return newConnection(dial), nil
+// NewClient connect to the server at address, through DefaultTransport
+// unless another transport is given with WithTransport
+func NewClient(address string, opts ...Option) (Connection, error) {
+	return newOptions(opts).transport.Dial(address)
 }
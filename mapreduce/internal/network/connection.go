@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+
+	"mapreduce/internal/metrics"
 )
 
 const (
@@ -13,6 +16,13 @@ const (
 	DELIM_SUFFIX = "\n"
 )
 
+var (
+	messagesSent     = metrics.NewCounter("network_messages_sent_total", "Messages written on the connections.")
+	messagesReceived = metrics.NewCounter("network_messages_received_total", "Messages read from the connections.")
+	bytesSent        = metrics.NewCounter("network_sent_bytes_total", "Bytes written on the connections.")
+	bytesReceived    = metrics.NewCounter("network_received_bytes_total", "Bytes read from the connections.")
+)
+
 type Connection interface {
 	Read() (string, error)
 	Write(string, ...any) (int, error)
@@ -23,6 +33,9 @@ type Connection interface {
 type connection struct {
 	conn net.Conn
 	rw   *bufio.ReadWriter
+	// mu serialize writers, responses and commands can be sent
+	// from different goroutines over the same connection
+	mu sync.Mutex
 }
 
 func newConnection(conn net.Conn) *connection {
@@ -38,16 +51,24 @@ func (c *connection) Read() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	messagesReceived.Inc()
+	bytesReceived.Add(float64(len(response)))
 	return strings.TrimSpace(response), nil
 }
 
 func (c *connection) Write(s string, args ...any) (int, error) {
 	msg := fmt.Sprintf(s, args...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	n, err := c.rw.WriteString(msg)
 	if err != nil {
 		return 0, err
 	}
 	err = c.rw.Flush()
+	if err == nil {
+		messagesSent.Add(float64(strings.Count(msg, DELIM_SUFFIX)))
+		bytesSent.Add(float64(n))
+	}
 	return n, err
 }
 
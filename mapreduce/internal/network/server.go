@@ -2,9 +2,10 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"strings"
 )
@@ -15,57 +16,85 @@ type Handler interface {
 
 type Server interface {
 	Run() error
+	// Serve accept connections on listener until it is closed
+	Serve(listener Listener) error
 	Log(string, ...any)
+	// Logger of the server, with its name
+	Logger() *slog.Logger
+}
+
+type loggerKey struct{}
+
+// Logger return the logger of the connection a handler is processing,
+// with the name of the server and the remote address
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
 }
 
 type server struct {
-	name    string
-	address string
-	handler Handler
+	name      string
+	address   string
+	handler   Handler
+	transport Transport
 }
 
-func NewServer(name, address string, handler Handler) Server {
+// NewServer create a server of handler, Run listens on address through
+// DefaultTransport unless another transport is given with WithTransport
+func NewServer(name, address string, handler Handler, opts ...Option) Server {
 	return &server{
-		name:    name,
-		address: address,
-		handler: handler,
+		name:      name,
+		address:   address,
+		handler:   handler,
+		transport: newOptions(opts).transport,
 	}
 }
 
 func (s *server) Run() error {
-	listener, err := net.Listen(PROTO, s.address)
+	listener, err := s.transport.Listen(s.address)
 	if err != nil {
 		return err
 	}
+	return s.Serve(listener)
+}
+
+func (s *server) Serve(listener Listener) error {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("listener: Accept error: %s", err.Error())
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.Logger().Error("accept error", "err", err)
 			continue
 		}
 		go s.process(conn)
 	}
 }
 
-func (s *server) process(conn net.Conn) {
+func (s *server) process(c Connection) {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
-	c := newConnection(conn)
 	ctx = context.WithValue(ctx, "connection", c)
+	logger := s.Logger().With("remote", c.RemoteAddress())
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
 	defer c.Close()
 	defer cancel()
 	for {
 		message, err := c.Read()
 		if err != nil {
-			s.Log("error reading message: %s", err)
 			if err == io.EOF {
-				s.Log("connection closed from: %s", c.RemoteAddress())
-				return
+				logger.Debug("connection closed")
+			} else {
+				logger.Warn("error reading message", "err", err)
 			}
+			return
 		}
 		response, err := s.handler.Process(ctx, message)
 		if err != nil {
-			s.Log("error processing response: %s", err)
+			logger.Warn("error processing message", "message", message, "err", err)
 			continue
 		}
 
@@ -76,17 +105,24 @@ func (s *server) process(conn net.Conn) {
 		if !strings.HasSuffix(response, DELIM_SUFFIX) {
 			response += DELIM_SUFFIX
 		}
-		_, err = c.Write(response)
+		_, err = c.Write("%s", response)
 		if err != nil {
-			s.Log("error writing response: %s", err)
+			logger.Warn("error writing response", "err", err)
 			if err == io.EOF {
-				s.Log("connection reset by peer %s", c.RemoteAddress())
+				logger.Info("connection reset by peer")
 				return
 			}
 		}
 	}
 }
 
+// Log an informational message formatted as fmt.Sprintf
 func (s *server) Log(msg string, args ...any) {
-	log.Printf("[server: %s] %s\n", s.name, fmt.Sprintf(msg, args...))
+	s.Logger().Info(fmt.Sprintf(msg, args...))
+}
+
+// Logger of the server, derived from the default logger when called
+// so the commands can configure it after creating the server
+func (s *server) Logger() *slog.Logger {
+	return slog.Default().With("server", s.name)
 }
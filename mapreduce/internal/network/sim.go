@@ -0,0 +1,569 @@
+package network
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SimConfig are the faults of a SimNetwork, every message is a line
+type SimConfig struct {
+	// MinDelay and MaxDelay bound the time a message takes to arrive, on the clock of the network
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// DropRate, DuplicateRate and ReorderRate are the probabilities that a
+	// message is lost, arrives twice or may arrive before the previous ones
+	DropRate      float64
+	DuplicateRate float64
+	ReorderRate   float64
+}
+
+// SimEvent is something that happened to a message in a SimNetwork
+type SimEvent struct {
+	// At is the time of the clock of the network
+	At   time.Duration
+	From string
+	To   string
+	Line string
+	// Kind is sent, dropped, duplicated, partitioned or delivered
+	Kind string
+}
+
+func (e SimEvent) String() string {
+	return fmt.Sprintf("%s %s %s -> %s: %s", e.At, e.Kind, e.From, e.To, e.Line)
+}
+
+// SimNetwork is an in memory Transport that delivers the lines written on its
+// connections with delays, losses, duplicates and reorders. It has its own clock,
+// moved by the test with Step and Advance, nothing arrives until then. The fate
+// of a message is decided by a random generator seeded for its connection, named
+// by its nodes, and messages arriving at the same time are delivered in order of
+// connection and sequence, so a seed reproduces the same run.
+//
+// A delivered line is handled when the goroutine reading it calls Read again or
+// closes the connection, Step and Advance deliver the next line once every line
+// is handled. Lines count when a goroutine waits for them in Read, and always on
+// the connections returned by Accept, that must be read as a Server does. A
+// goroutine reading a line must not wait for the one calling Step before it reads
+// again. The answers sent from other goroutines, as the workers running their
+// tasks, are not waited for.
+//
+// Nodes are named by the address they listen on, or by the name given to Node,
+// and can be partitioned
+type SimNetwork struct {
+	config SimConfig
+	seed   int64
+
+	mu        sync.Mutex
+	listeners map[string]*simListener
+	// conns counts the connections between two nodes to name the next one
+	conns  map[string]int
+	groups map[string]int
+	events []SimEvent
+	queue  simQueue
+	// now is the clock of the network
+	now time.Duration
+	// live are the open connections, unhandled the lines delivered and not handled yet
+	live      map[*simConn]bool
+	unhandled int
+	handled   *sync.Cond
+	closed    bool
+}
+
+// NewSimNetwork create a simulated network, its clock starts at zero
+func NewSimNetwork(seed int64, config SimConfig) *SimNetwork {
+	s := &SimNetwork{
+		config:    config,
+		seed:      seed,
+		listeners: map[string]*simListener{},
+		conns:     map[string]int{},
+		live:      map[*simConn]bool{},
+	}
+	s.handled = sync.NewCond(&s.mu)
+	return s
+}
+
+// Node return a transport whose connections come from the node name
+func (s *SimNetwork) Node(name string) Transport {
+	return &simNode{network: s, name: name}
+}
+
+// Listen on address, the address is the name of the node
+func (s *SimNetwork) Listen(address string) (Listener, error) {
+	return s.listen(address, address)
+}
+
+// Dial address from the anonymous node client
+func (s *SimNetwork) Dial(address string) (Connection, error) {
+	return s.dial("client", address)
+}
+
+// Partition split the nodes in groups that can not talk to each other,
+// nodes not named talk with every group. Messages in flight between
+// groups are lost when they arrive
+func (s *SimNetwork) Partition(groups ...[]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = map[string]int{}
+	for i, group := range groups {
+		for _, node := range group {
+			s.groups[node] = i
+		}
+	}
+}
+
+// Heal the partitions
+func (s *SimNetwork) Heal() {
+	s.Partition()
+}
+
+// Events of the messages sent so far
+func (s *SimNetwork) Events() []SimEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SimEvent(nil), s.events...)
+}
+
+// Now is the time of the clock of the network
+func (s *SimNetwork) Now() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+// Pending is the number of messages in flight
+func (s *SimNetwork) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.Len()
+}
+
+// Step wait until the lines delivered are handled, move the clock to the arrival of
+// the next message in flight and deliver it. It returns its event, delivered or
+// partitioned, and false when none is in flight
+func (s *SimNetwork) Step() (SimEvent, bool) {
+	s.mu.Lock()
+	s.settle()
+	if s.queue.Len() == 0 {
+		s.mu.Unlock()
+		return SimEvent{}, false
+	}
+	m := heap.Pop(&s.queue).(*simMessage)
+	s.now = max(s.now, m.at)
+	event, deliver := s.arrive(m)
+	s.mu.Unlock()
+	if deliver {
+		m.to.receive(m.line)
+	}
+	return event, true
+}
+
+// Advance the clock by d delivering the messages that arrive meanwhile,
+// the answers to them included, returns their events
+func (s *SimNetwork) Advance(d time.Duration) []SimEvent {
+	s.mu.Lock()
+	until := s.now + d
+	s.mu.Unlock()
+	var events []SimEvent
+	for {
+		s.mu.Lock()
+		s.settle()
+		if s.queue.Len() == 0 || s.queue[0].at > until {
+			s.now = max(s.now, until)
+			s.mu.Unlock()
+			return events
+		}
+		s.mu.Unlock()
+		event, _ := s.Step()
+		events = append(events, event)
+	}
+}
+
+// settle wait with the lock held until every line delivered is handled
+func (s *SimNetwork) settle() {
+	for s.unhandled > 0 && !s.closed {
+		s.handled.Wait()
+	}
+}
+
+// handle record that n lines delivered were handled
+func (s *SimNetwork) handle(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.unhandled -= n
+	s.mu.Unlock()
+	s.handled.Broadcast()
+}
+
+// arrive record the arrival of a message, it is delivered unless its nodes are
+// partitioned
+func (s *SimNetwork) arrive(m *simMessage) (SimEvent, bool) {
+	event := SimEvent{At: s.now, From: m.from, To: m.to.node, Line: strings.TrimSpace(m.line), Kind: "delivered"}
+	if s.partitioned(m.from, m.to.node) {
+		event.Kind = "partitioned"
+	}
+	s.events = append(s.events, event)
+	return event, event.Kind == "delivered"
+}
+
+// Close the listeners and the connections, messages in flight are lost
+func (s *SimNetwork) Close() {
+	s.mu.Lock()
+	s.closed = true
+	listeners, live := s.listeners, s.live
+	s.listeners, s.live = map[string]*simListener{}, map[*simConn]bool{}
+	s.queue = nil
+	s.mu.Unlock()
+	s.handled.Broadcast()
+	for _, l := range listeners {
+		l.Close()
+	}
+	for c := range live {
+		c.close()
+	}
+}
+
+func (s *SimNetwork) listen(node, address string) (Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.listeners[address]; ok {
+		return nil, fmt.Errorf("listen %s: address already in use", address)
+	}
+	l := &simListener{
+		network: s,
+		node:    node,
+		address: address,
+		accept:  make(chan *simConn, 16),
+		done:    make(chan struct{}),
+	}
+	s.listeners[address] = l
+	return l, nil
+}
+
+// dial address from node, the connection is named by its nodes and
+// the number of connections between them, node->address#1
+func (s *SimNetwork) dial(node, address string) (Connection, error) {
+	s.mu.Lock()
+	l, ok := s.listeners[address]
+	if !ok || s.closed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: connection refused", address)
+	}
+	pair := node + "->" + l.node
+	s.conns[pair]++
+	n := s.conns[pair]
+	client := newSimConn(s, node, l.node, fmt.Sprintf("%s#%d", pair, n))
+	server := newSimConn(s, l.node, node, fmt.Sprintf("%s#%d<-", pair, n))
+	client.peer, server.peer = server, client
+	server.accepted = true
+	s.live[client], s.live[server] = true, true
+	s.mu.Unlock()
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dial %s: connection refused", address)
+	}
+}
+
+// send schedule the lines written on a connection, must be called with the lock of the connection
+func (s *SimNetwork) send(c *simConn, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	event := SimEvent{At: s.now, From: c.node, To: c.peerNode, Line: strings.TrimSpace(line), Kind: "sent"}
+	s.events = append(s.events, event)
+	if c.rand.Float64() < s.config.DropRate {
+		event.Kind = "dropped"
+		s.events = append(s.events, event)
+		return
+	}
+	copies := 1
+	if c.rand.Float64() < s.config.DuplicateRate {
+		event.Kind = "duplicated"
+		s.events = append(s.events, event)
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		at := s.now + s.delay(c.rand)
+		// messages arrive in order unless they are reordered
+		if c.rand.Float64() >= s.config.ReorderRate && at < c.lastAt {
+			at = c.lastAt
+		}
+		c.lastAt = max(c.lastAt, at)
+		c.seq++
+		heap.Push(&s.queue, &simMessage{to: c.peer, from: c.node, line: line, at: at, conn: c.id, seq: c.seq})
+	}
+}
+
+func (s *SimNetwork) delay(r *rand.Rand) time.Duration {
+	d := s.config.MinDelay
+	if s.config.MaxDelay > d {
+		d += time.Duration(r.Int63n(int64(s.config.MaxDelay - d)))
+	}
+	return d
+}
+
+// partitioned tell if the nodes are in different groups
+func (s *SimNetwork) partitioned(a, b string) bool {
+	ga, okA := s.groups[a]
+	gb, okB := s.groups[b]
+	return okA && okB && ga != gb
+}
+
+// seedFor a connection, the same connection gets the same seed on every run
+func (s *SimNetwork) seedFor(id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return s.seed ^ int64(h.Sum64())
+}
+
+type simNode struct {
+	network *SimNetwork
+	name    string
+}
+
+func (n *simNode) Listen(address string) (Listener, error) {
+	return n.network.listen(n.name, address)
+}
+
+func (n *simNode) Dial(address string) (Connection, error) {
+	return n.network.dial(n.name, address)
+}
+
+type simListener struct {
+	network *SimNetwork
+	node    string
+	address string
+	accept  chan *simConn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *simListener) Accept() (Connection, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.network.mu.Lock()
+		if l.network.listeners[l.address] == l {
+			delete(l.network.listeners, l.address)
+		}
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *simListener) Addr() string {
+	return l.address
+}
+
+// simConn is an end of a connection, its peer receives the lines it writes
+type simConn struct {
+	network  *SimNetwork
+	id       string
+	node     string
+	peerNode string
+	peer     *simConn
+	rand     *rand.Rand
+	// lastAt is the arrival time of the last message sent, to keep them in order,
+	// and seq the number of messages sent, guarded by the lock of the network
+	lastAt time.Duration
+	seq    int
+
+	// accepted is the end returned by Accept, it is always read
+	accepted bool
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	inbox   []simLine
+	ready   chan struct{}
+	closed  bool
+	// reading is true while a goroutine waits in Read, returned is the number of
+	// counted lines Read returned, handled when it is called again
+	reading  bool
+	returned int
+}
+
+// simLine is a line delivered to a connection, counted until it is handled
+type simLine struct {
+	text    string
+	counted bool
+}
+
+func newSimConn(s *SimNetwork, node, peerNode, id string) *simConn {
+	return &simConn{
+		network:  s,
+		id:       id,
+		node:     node,
+		peerNode: peerNode,
+		rand:     rand.New(rand.NewSource(s.seedFor(id))),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// Read the next line delivered, the lines returned before are handled
+func (c *simConn) Read() (string, error) {
+	c.mu.Lock()
+	handled := c.returned
+	c.returned = 0
+	for len(c.inbox) == 0 && !c.closed {
+		c.reading = true
+		c.mu.Unlock()
+		c.network.handle(handled)
+		handled = 0
+		<-c.ready
+		c.mu.Lock()
+		c.reading = false
+	}
+	if len(c.inbox) == 0 {
+		c.mu.Unlock()
+		c.network.handle(handled)
+		return "", io.EOF
+	}
+	line := c.inbox[0]
+	c.inbox = c.inbox[1:]
+	if line.counted {
+		c.returned++
+	}
+	c.mu.Unlock()
+	c.network.handle(handled)
+	return strings.TrimSpace(line.text), nil
+}
+
+// Write send each line of the message as a message of the network
+func (c *simConn) Write(s string, args ...any) (int, error) {
+	msg := fmt.Sprintf(s, args...)
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, io.EOF
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	for _, line := range strings.SplitAfter(msg, DELIM_SUFFIX) {
+		if line != "" {
+			c.network.send(c, line)
+		}
+	}
+	return len(msg), nil
+}
+
+// Close both ends, the peer reads the messages already delivered and then io.EOF.
+// The lines delivered to this end are handled
+func (c *simConn) Close() {
+	c.close()
+	c.peer.close()
+	c.mu.Lock()
+	handled := c.returned
+	c.returned = 0
+	for _, line := range c.inbox {
+		if line.counted {
+			handled++
+		}
+	}
+	c.inbox = nil
+	c.mu.Unlock()
+	c.network.handle(handled)
+}
+
+func (c *simConn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.signal()
+	c.network.mu.Lock()
+	delete(c.network.live, c)
+	c.network.mu.Unlock()
+}
+
+// receive a line delivered by the network, it is counted until handled when it
+// is read, see SimNetwork
+func (c *simConn) receive(line string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	counted := c.accepted || c.reading
+	if counted {
+		c.network.mu.Lock()
+		c.network.unhandled++
+		c.network.mu.Unlock()
+	}
+	c.inbox = append(c.inbox, simLine{text: line, counted: counted})
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *simConn) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *simConn) RemoteAddress() string {
+	return "sim:" + c.peerNode
+}
+
+type simMessage struct {
+	to   *simConn
+	from string
+	line string
+	at   time.Duration
+	// conn is the connection that sent the message and seq its number on it
+	conn string
+	seq  int
+}
+
+// simQueue is a heap of messages ordered by arrival time, connection and sequence,
+// the order does not depend on the order the goroutines sent them
+type simQueue []*simMessage
+
+func (q simQueue) Len() int {
+	return len(q)
+}
+
+func (q simQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	if q[i].conn != q[j].conn {
+		return q[i].conn < q[j].conn
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q simQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *simQueue) Push(x any) {
+	*q = append(*q, x.(*simMessage))
+}
+
+func (q *simQueue) Pop() any {
+	old := *q
+	m := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return m
+}
@@ -0,0 +1,94 @@
+package network_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "mapreduce/internal/jobs"
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/master"
+	"mapreduce/internal/mrtest"
+	n "mapreduce/internal/network"
+)
+
+// run steps the network until the client read a line containing until
+func run(t *testing.T, sim *n.SimNetwork, lines <-chan string, until string) []string {
+	t.Helper()
+	var read []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		select {
+		case line := <-lines:
+			read = append(read, line)
+			if strings.Contains(line, until) {
+				return read
+			}
+		default:
+			sim.Step()
+		}
+	}
+	t.Fatalf("timeout waiting for %q, read %q", until, read)
+	return nil
+}
+
+// a job runs over the simulated network with delays, driven by the test
+func TestSimWordcount(t *testing.T) {
+	sim := n.NewSimNetwork(1, n.SimConfig{MaxDelay: 5 * time.Millisecond})
+	defer sim.Close()
+	defaults, err := mr.ParseJobConfig([]string{"job=wordcount"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := master.New("master", defaults, 3, t.TempDir(), nil)
+	listener, err := sim.Listen("master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go n.NewServer("master", "master", handler).Serve(listener)
+	for _, kind := range []string{"mapper", "reducer"} {
+		newServer := mr.NewMapServer
+		if kind == "reducer" {
+			newServer = mr.NewReduceServer
+		}
+		worker, err := newServer("master", 1, "wordcount")
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker.Transport = sim.Node(kind + "-0")
+		go worker.Run()
+		defer worker.Close()
+	}
+	// the workers are registered before the job is submitted
+	for accepted, deadline := 0, time.Now().Add(10*time.Second); accepted < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the workers to register")
+		}
+		if event, ok := sim.Step(); ok && strings.Contains(event.Line, " accepted ") {
+			accepted++
+		}
+	}
+	client, err := n.NewClient("master", n.WithTransport(sim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// the reader does not wait for the test stepping the network
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			line, err := client.Read()
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	input, err := filepath.Abs("../jobs/testdata/wordcount/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write("process %s\n", input)
+	read := run(t, sim, lines, "finished elapsed time")
+	mrtest.AssertGolden(t, mrtest.ReadOutput(t, read, "csv"), "../jobs/testdata/wordcount/output.golden")
+}
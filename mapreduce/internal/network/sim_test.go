@@ -0,0 +1,204 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type echoHandler struct{}
+
+func (echoHandler) Process(_ context.Context, cmd string) (string, error) {
+	return "ECHO " + cmd, nil
+}
+
+// dialSim retry until the server listens
+func dialSim(t *testing.T, transport Transport, address string) Connection {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		conn, err := NewClient(address, WithTransport(transport))
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("can not dial %s: %s", address, err)
+		}
+	}
+}
+
+// nothing arrives until the test moves the clock
+func TestSimClock(t *testing.T) {
+	sim := NewSimNetwork(1, SimConfig{MinDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond})
+	defer sim.Close()
+	go NewServer("echo", "echo", echoHandler{}, WithTransport(sim)).Run()
+	conn := dialSim(t, sim.Node("client-0"), "echo")
+	_, err := conn.Write("hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := sim.Advance(5 * time.Millisecond); len(events) != 0 {
+		t.Fatalf("delivered before its delay: %v", events)
+	}
+	// the request arrives at 10ms and the answer at 20ms
+	events := sim.Advance(15 * time.Millisecond)
+	if len(events) != 2 || events[0].At != 10*time.Millisecond || events[1].At != 20*time.Millisecond {
+		t.Fatalf("unexpected deliveries %v", events)
+	}
+	if now := sim.Now(); now != 20*time.Millisecond {
+		t.Errorf("clock at %s, want 20ms", now)
+	}
+	line, err := conn.Read()
+	if err != nil || line != "ECHO hello" {
+		t.Errorf("read %q %v, want ECHO hello", line, err)
+	}
+}
+
+// simRun send lines to an echo server on concurrent connections and return the
+// deliveries, the answers included, and the number of events of each kind
+func simRun(t *testing.T, seed int64) ([]string, map[string]int) {
+	sim := NewSimNetwork(seed, SimConfig{MaxDelay: 10 * time.Millisecond, DropRate: 0.1, DuplicateRate: 0.1, ReorderRate: 0.2})
+	defer sim.Close()
+	listener, err := sim.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewServer("echo", "server", echoHandler{}).Serve(listener)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		conn, err := sim.Node(fmt.Sprintf("node-%d", i)).Dial("server")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				conn.Write("line %d %d\n", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	var deliveries []string
+	for {
+		event, ok := sim.Step()
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, event.String())
+	}
+	kinds := map[string]int{}
+	for _, event := range sim.Events() {
+		kinds[event.Kind]++
+		if event.Kind == "delivered" && event.To == "server" {
+			kinds["answered"]++
+		}
+	}
+	return deliveries, kinds
+}
+
+// a seed reproduces the same deliveries whatever the order the goroutines send
+func TestSimDeterministic(t *testing.T) {
+	first, kinds := simRun(t, 42)
+	for i := 0; i < 5; i++ {
+		if again, _ := simRun(t, 42); !slices.Equal(again, first) {
+			t.Fatalf("run %d differs:\n%q\nfirst:\n%q", i, again, first)
+		}
+	}
+	if other, _ := simRun(t, 7); slices.Equal(other, first) {
+		t.Error("another seed gave the same deliveries")
+	}
+	// the server answers every line delivered to it
+	if kinds["sent"] != 60+kinds["answered"] || kinds["dropped"] == 0 || kinds["duplicated"] == 0 {
+		t.Errorf("expected 60 lines and their answers sent with drops and duplicates, got %v", kinds)
+	}
+	if want := kinds["sent"] - kinds["dropped"] + kinds["duplicated"]; len(first) != want {
+		t.Errorf("%d deliveries, want %d", len(first), want)
+	}
+}
+
+func TestSimDrop(t *testing.T) {
+	sim := NewSimNetwork(1, SimConfig{DropRate: 1})
+	defer sim.Close()
+	if _, err := sim.Listen("server"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sim.Dial("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write("lost\n")
+	if n := sim.Pending(); n != 0 {
+		t.Errorf("%d messages in flight, want 0", n)
+	}
+	events := sim.Events()
+	if len(events) != 2 || events[1].Kind != "dropped" || events[1].From != "client" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestSimPartition(t *testing.T) {
+	sim := NewSimNetwork(1, SimConfig{})
+	defer sim.Close()
+	listener, err := sim.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sim.Node("worker").Dial("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Partition([]string{"server"}, []string{"worker"})
+	conn.Write("lost\n")
+	if event, ok := sim.Step(); !ok || event.Kind != "partitioned" {
+		t.Fatalf("expected the message to be partitioned, got %v", event)
+	}
+	sim.Heal()
+	conn.Write("healed\n")
+	if event, ok := sim.Step(); !ok || event.Kind != "delivered" {
+		t.Fatalf("expected the message to be delivered, got %v", event)
+	}
+	if line, err := server.Read(); err != nil || line != "healed" {
+		t.Errorf("read %q %v, want healed", line, err)
+	}
+}
+
+// closing the network closes the connections, their readers get io.EOF
+func TestSimClose(t *testing.T) {
+	sim := NewSimNetwork(1, SimConfig{})
+	listener, err := sim.Listen("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sim.Dial("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 2)
+	for _, c := range []Connection{conn, server} {
+		go func(c Connection) {
+			_, err := c.Read()
+			errs <- err
+		}(c)
+	}
+	sim.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != io.EOF {
+			t.Errorf("read %v, want io.EOF", err)
+		}
+	}
+	if _, err := sim.Dial("server"); err == nil {
+		t.Error("dial succeeded on a closed network")
+	}
+}
@@ -0,0 +1,86 @@
+package network
+
+import "net"
+
+// Listener accept the connections of a server
+type Listener interface {
+	Accept() (Connection, error)
+	Close() error
+	Addr() string
+}
+
+// Transport create the listeners of servers and the connections of clients
+type Transport interface {
+	Listen(address string) (Listener, error)
+	Dial(address string) (Connection, error)
+}
+
+// TCP is the transport of the master and the workers
+var TCP Transport = tcpTransport{}
+
+// DefaultTransport is used by NewClient and Server.Run without WithTransport, TCP
+// unless it is replaced
+var DefaultTransport = TCP
+
+// Option of a server or a client
+type Option func(*options)
+
+type options struct {
+	transport Transport
+}
+
+// WithTransport make a server listen or a client dial through t, a SimNetwork
+// or one of its nodes in tests. A nil t keeps DefaultTransport
+func WithTransport(t Transport) Option {
+	return func(o *options) {
+		if t != nil {
+			o.transport = t
+		}
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{transport: DefaultTransport}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(address string) (Listener, error) {
+	listener, err := net.Listen(PROTO, address)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpListener{listener: listener}, nil
+}
+
+func (tcpTransport) Dial(address string) (Connection, error) {
+	conn, err := net.Dial(PROTO, address)
+	if err != nil {
+		return nil, err
+	}
+	return newConnection(conn), nil
+}
+
+type tcpListener struct {
+	listener net.Listener
+}
+
+func (l *tcpListener) Accept() (Connection, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newConnection(conn), nil
+}
+
+func (l *tcpListener) Close() error {
+	return l.listener.Close()
+}
+
+func (l *tcpListener) Addr() string {
+	return l.listener.Addr().String()
+}
@@ -0,0 +1,357 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopeName of the spans in the OTLP documents
+const scopeName = "mapreduce"
+
+// the documents of OTLP json, an ExportTraceServiceRequest with the spans of each service.
+// Ids are hex strings and 64 bits integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// otlpStatus code is 1 for ok and 2 for error
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func toOTLP(spans []SpanData) otlpRequest {
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{}}
+	byService := map[string]int{}
+	for _, s := range spans {
+		i, ok := byService[s.Service]
+		if !ok {
+			i = len(req.ResourceSpans)
+			byService[s.Service] = i
+			service := s.Service
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{Attributes: []otlpKeyValue{
+					{Key: "service.name", Value: otlpAnyValue{StringValue: &service}},
+				}},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}}},
+			})
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, spanToOTLP(s))
+	}
+	return req
+}
+
+func spanToOTLP(s SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           s.TraceID.String(),
+		SpanID:            s.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: 1},
+	}
+	if s.Parent.IsValid() {
+		span.ParentSpanID = s.Parent.String()
+	}
+	if s.Error != "" {
+		span.Status = otlpStatus{Code: 2, Message: s.Error}
+	}
+	keys := make([]string, 0, len(s.Attributes))
+	for key := range s.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var value otlpAnyValue
+		switch v := s.Attributes[key].(type) {
+		case int64:
+			i := strconv.FormatInt(v, 10)
+			value.IntValue = &i
+		case float64:
+			value.DoubleValue = &v
+		case bool:
+			value.BoolValue = &v
+		default:
+			str := fmt.Sprint(v)
+			value.StringValue = &str
+		}
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: key, Value: value})
+	}
+	return span
+}
+
+func fromOTLP(req otlpRequest) ([]SpanData, error) {
+	var spans []SpanData
+	for _, rs := range req.ResourceSpans {
+		var service string
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" && attr.Value.StringValue != nil {
+				service = *attr.Value.StringValue
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				s, err := spanFromOTLP(span)
+				if err != nil {
+					return nil, err
+				}
+				s.Service = service
+				spans = append(spans, s)
+			}
+		}
+	}
+	return spans, nil
+}
+
+func spanFromOTLP(span otlpSpan) (SpanData, error) {
+	s := SpanData{Name: span.Name, Kind: span.Kind, Attributes: map[string]any{}}
+	err := decodeHex(s.TraceID[:], span.TraceID)
+	if err == nil {
+		err = decodeHex(s.SpanID[:], span.SpanID)
+	}
+	if err == nil && span.ParentSpanID != "" {
+		err = decodeHex(s.Parent[:], span.ParentSpanID)
+	}
+	if err != nil {
+		return s, fmt.Errorf("span %s: %w", span.Name, err)
+	}
+	start, err := strconv.ParseInt(span.StartTimeUnixNano, 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("span %s: invalid start time", span.Name)
+	}
+	end, err := strconv.ParseInt(span.EndTimeUnixNano, 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("span %s: invalid end time", span.Name)
+	}
+	s.Start, s.End = time.Unix(0, start), time.Unix(0, end)
+	if span.Status.Code == 2 {
+		s.Error = span.Status.Message
+	}
+	for _, attr := range span.Attributes {
+		v := attr.Value
+		switch {
+		case v.StringValue != nil:
+			s.Attributes[attr.Key] = *v.StringValue
+		case v.IntValue != nil:
+			i, err := strconv.ParseInt(*v.IntValue, 10, 64)
+			if err != nil {
+				return s, fmt.Errorf("span %s: invalid attribute %s", span.Name, attr.Key)
+			}
+			s.Attributes[attr.Key] = i
+		case v.DoubleValue != nil:
+			s.Attributes[attr.Key] = *v.DoubleValue
+		case v.BoolValue != nil:
+			s.Attributes[attr.Key] = *v.BoolValue
+		}
+	}
+	return s, nil
+}
+
+// WriteOTLP write the spans as a single OTLP json document
+func WriteOTLP(w io.Writer, spans []SpanData) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toOTLP(spans))
+}
+
+// ReadOTLP read the spans of OTLP json documents, one after the other as
+// written by WriteOTLP or one per line as written by a FileExporter
+func ReadOTLP(r io.Reader) ([]SpanData, error) {
+	var spans []SpanData
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var req otlpRequest
+		err := dec.Decode(&req)
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		read, err := fromOTLP(req)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, read...)
+	}
+}
+
+// FileExporter write each span as a line of OTLP json, the format of the
+// file exporter of the OpenTelemetry collector. Processes can append to the same file
+type FileExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewFileExporter(w io.Writer) *FileExporter {
+	return &FileExporter{w: w}
+}
+
+func (f *FileExporter) Export(span SpanData) {
+	data, err := json.Marshal(toOTLP([]SpanData{span}))
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.w.Write(append(data, '\n'))
+}
+
+// ExportToFile create a FileExporter appending to the file with name
+func ExportToFile(name string) (*FileExporter, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileExporter(f), nil
+}
+
+// Collector keep the spans in memory, a stand-in of an OpenTelemetry collector
+// for the tests, the spans of every process of a cluster run inside a test
+type Collector struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Export(span SpanData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, span)
+}
+
+// Spans exported, in the order they ended
+func (c *Collector) Spans() []SpanData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SpanData(nil), c.spans...)
+}
+
+// Roots are the root spans, the jobs of the master, in the order they started
+func (c *Collector) Roots() []SpanData {
+	var roots []SpanData
+	for _, s := range c.Spans() {
+		if !s.Parent.IsValid() {
+			roots = append(roots, s)
+		}
+	}
+	sortByStart(roots)
+	return roots
+}
+
+// Trace return the spans of a trace, in the order they started
+func (c *Collector) Trace(id TraceID) []SpanData {
+	var spans []SpanData
+	for _, s := range c.Spans() {
+		if s.TraceID == id {
+			spans = append(spans, s)
+		}
+	}
+	sortByStart(spans)
+	return spans
+}
+
+// Tree describe the spans of a trace, a line for each one with its service and
+// its name indented under its parent, children in the order they started:
+//
+//	master: job
+//	  master: stage 0 wordcount
+//	    master: map
+//	      master: map m-0
+//	        mapper 0: map m-0
+//
+// Failed spans end with their error, spans whose parent was not exported are roots
+func (c *Collector) Tree(id TraceID) string {
+	spans := c.Trace(id)
+	exported := map[SpanID]bool{}
+	for _, s := range spans {
+		exported[s.SpanID] = true
+	}
+	children := map[SpanID][]SpanData{}
+	var roots []SpanData
+	for _, s := range spans {
+		if s.Parent.IsValid() && exported[s.Parent] {
+			children[s.Parent] = append(children[s.Parent], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+	var sb strings.Builder
+	var write func(s SpanData, depth int)
+	write = func(s SpanData, depth int) {
+		fmt.Fprintf(&sb, "%s%s: %s", strings.Repeat("  ", depth), s.Service, s.Name)
+		if s.Error != "" {
+			fmt.Fprintf(&sb, " error: %s", s.Error)
+		}
+		sb.WriteString("\n")
+		for _, child := range children[s.SpanID] {
+			write(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		write(root, 0)
+	}
+	return sb.String()
+}
+
+func sortByStart(spans []SpanData) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+}
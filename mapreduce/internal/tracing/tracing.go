@@ -0,0 +1,253 @@
+// Package tracing records the spans of a job across the master and the workers.
+// The master sends the trace and the span of each task in the traceparent option
+// of the map and reduce commands, the worker running it starts a child span.
+// Ended spans go to the exporter they were started with, each master and worker
+// has its own. A FileExporter writes them as OTLP json and a Collector keeps
+// them in memory for the tests
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies the spans of a job
+type TraceID [16]byte
+
+// SpanID identifies a span in its trace
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span sent to other processes, the parent of their spans
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceID.IsValid() && c.SpanID.IsValid()
+}
+
+// String encode the context as a traceparent of w3c trace context, 00-<trace>-<span>-01
+func (c SpanContext) String() string {
+	return fmt.Sprintf("00-%s-%s-01", c.TraceID, c.SpanID)
+}
+
+// ParseSpanContext read a traceparent written by SpanContext.String
+func ParseSpanContext(s string) (SpanContext, error) {
+	var c SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return c, fmt.Errorf("invalid traceparent %s", s)
+	}
+	err := decodeHex(c.TraceID[:], parts[1])
+	if err == nil {
+		err = decodeHex(c.SpanID[:], parts[2])
+	}
+	if err != nil || !c.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %s", s)
+	}
+	return c, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return fmt.Errorf("expected %d hex digits, got %d", len(dst)*2, len(s))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// SpanKind of the OTLP spans, the master is the client of the tasks and the workers their servers
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// SpanData is an ended span, as exported
+type SpanData struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Parent is not valid for the root span of a trace
+	Parent SpanID
+	// Service is the process that recorded the span, master or the worker, mapper 0
+	Service string
+	Name    string
+	Kind    SpanKind
+	Start   time.Time
+	End     time.Time
+	// Attributes are string, int64, float64 or bool
+	Attributes map[string]any
+	// Error is the message of the error that ended the span, empty when it succeeded
+	Error string
+}
+
+// Span is running until End is called, a nil span records nothing so
+// the code does not have to check if a job is traced
+type Span struct {
+	mu       sync.Mutex
+	data     SpanData
+	ended    bool
+	exporter Exporter
+}
+
+// NewSpan start a span of service, child of parent, or the root of a new trace
+// when parent is not valid. The span is sent to exporter when it ends, it is
+// recorded but not exported when exporter is nil
+func NewSpan(exporter Exporter, parent SpanContext, service, name string, kind SpanKind) *Span {
+	data := SpanData{
+		TraceID:    parent.TraceID,
+		Parent:     parent.SpanID,
+		Service:    service,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]any{},
+	}
+	if !parent.IsValid() {
+		data.TraceID = TraceID(randomBytes(16))
+		data.Parent = SpanID{}
+	}
+	data.SpanID = SpanID(randomBytes(8))
+	return &Span{data: data, exporter: exporter}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("can not generate ids: %s", err))
+	}
+	return b
+}
+
+// Start a child of the span of ctx in the same service and with the same
+// exporter, nil when ctx has no span
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	parent.mu.Lock()
+	service := parent.data.Service
+	parent.mu.Unlock()
+	span := NewSpan(parent.exporter, parent.Context(), service, name, KindInternal)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Context of the span to send to other processes, not valid for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+
+// SetAttributes set key value pairs, as the arguments of slog. Integers
+// are kept as int64, floats as float64 and other values as their string
+func (s *Span) SetAttributes(args ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		s.data.Attributes[key] = attributeValue(args[i+1])
+	}
+}
+
+func attributeValue(v any) any {
+	switch v := v.(type) {
+	case string, int64, float64, bool:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// End the span and send it to the exporter, err is the outcome of the
+// span, nil when it succeeded. Only the first call ends the span
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	if s.exporter != nil {
+		s.exporter.Export(data)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan return a copy of ctx carrying span, the parent of the spans of Start
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext return the span of ctx, nil when it has none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Exporter receive the ended spans of a master or a worker
+type Exporter interface {
+	Export(span SpanData)
+}
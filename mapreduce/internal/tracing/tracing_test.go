@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSpanContextRoundTrip(t *testing.T) {
+	span := NewSpan(nil, SpanContext{}, "master", "job", KindInternal)
+	parsed, err := ParseSpanContext(span.Context().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != span.Context() {
+		t.Errorf("parsed %s, want %s", parsed, span.Context())
+	}
+	for _, invalid := range []string{"", "00-abc-def-01", "01-" + span.Context().String()[3:]} {
+		if _, err := ParseSpanContext(invalid); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+// every span goes to the exporter of its root, started spans inherit it
+func TestSpansExportedToTheirExporter(t *testing.T) {
+	first, second := NewCollector(), NewCollector()
+	root := NewSpan(first, SpanContext{}, "mapper 0", "map m-0", KindServer)
+	remote := NewSpan(second, root.Context(), "master", "dispatch", KindClient)
+	_, child := Start(ContextWithSpan(context.Background(), root), "combine")
+	child.End(errors.New("failed"))
+	child.End(nil)
+	remote.End(nil)
+	root.End(nil)
+	spans := first.Spans()
+	if len(spans) != 2 || spans[0].Name != "combine" || spans[1].Name != "map m-0" {
+		t.Fatalf("first exporter got %v", spans)
+	}
+	if spans[0].Parent != root.Context().SpanID || spans[0].Service != "mapper 0" || spans[0].Error != "failed" {
+		t.Errorf("child span %+v", spans[0])
+	}
+	if spans := second.Spans(); len(spans) != 1 || spans[0].TraceID != root.Context().TraceID {
+		t.Errorf("second exporter got %v", spans)
+	}
+}
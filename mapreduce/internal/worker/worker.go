@@ -0,0 +1,61 @@
+// Package worker is the command of the mappers and the reducers, they share
+// their flags and the setup of the logs, the metrics and the traces
+package worker
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	_ "mapreduce/internal/jobs"
+	"mapreduce/internal/logging"
+	mr "mapreduce/internal/mapreduce"
+	"mapreduce/internal/metrics"
+	"mapreduce/internal/tracing"
+)
+
+// NewServer create the stage server of a worker, mr.NewMapServer or mr.NewReduceServer
+type NewServer func(masterAddress string, slots int, jobs ...string) (*mr.StageServer, error)
+
+// Main parse the flags of a worker, set up its logs, metrics and traces
+// and run the server created by newServer, kind is mapper or reducer
+func Main(kind string, newServer NewServer) {
+	var master string
+	flag.StringVar(&master, "master", "localhost:8000", "master address, default localhost:8000")
+	var jobs string
+	flag.StringVar(&jobs, "jobs", strings.Join(mr.RegisteredJobs(), ","), "comma separated jobs to run, default all the registered jobs")
+	slots := 1
+	flag.IntVar(&slots, "slots", slots, "tasks run at the same time, default: 1")
+	var metricsAddress string
+	flag.StringVar(&metricsAddress, "metrics", "", "address to serve prometheus metrics on /metrics, for example localhost:9100, disabled by default")
+	var traceFile string
+	flag.StringVar(&traceFile, "trace-file", "", "file where the spans of the jobs are appended as OTLP json, disabled by default")
+	var logs logging.Config
+	logs.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logs.Setup(); err != nil {
+		log.Fatal(err)
+	}
+	var exporter tracing.Exporter
+	if traceFile != "" {
+		file, err := tracing.ExportToFile(traceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exporter = file
+	}
+	if metricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Default)
+			log.Fatal(http.ListenAndServe(metricsAddress, mux))
+		}()
+	}
+	server, err := newServer(master, slots, strings.Split(jobs, ",")...)
+	if err != nil {
+		log.Fatal("can not start "+kind, err)
+	}
+	server.Exporter = exporter
+	log.Fatal(server.Run())
+}